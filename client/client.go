@@ -0,0 +1,178 @@
+// Package client calls the poll, voter and votes APIs. Requests and
+// responses use the types in package schema, every call takes a context,
+// and failed calls return an *Error that can be matched against
+// ErrNotFound, ErrConflict, ErrInvalid and ErrUnavailable with errors.Is.
+// GET, PUT and DELETE requests that fail on the network or with a 5xx are
+// retried a few times with backoff; other requests are sent once, as they
+// may not be safe to repeat.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	retryAttempts = 3                      // Including the first one
+	retryBackoff  = 100 * time.Millisecond // Doubled after every failed attempt
+)
+
+// Config says where the APIs are. Empty URLs default to the ports the APIs
+// listen on locally, and a nil HTTPClient to http.DefaultClient.
+type Config struct {
+	PollAPIURL  string
+	VoterAPIURL string
+	VotesAPIURL string
+	HTTPClient  *http.Client
+}
+
+// Client calls the three APIs. It is safe for concurrent use.
+type Client struct {
+	pollAPI  string
+	voterAPI string
+	votesAPI string
+	http     *http.Client
+}
+
+func New(config Config) *Client {
+	c := &Client{
+		pollAPI:  orDefault(config.PollAPIURL, "http://localhost:2080"),
+		voterAPI: orDefault(config.VoterAPIURL, "http://localhost:1080"),
+		votesAPI: orDefault(config.VotesAPIURL, "http://localhost:3080"),
+		http:     config.HTTPClient,
+	}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	return c
+}
+
+func orDefault(value string, defaultVal string) string {
+	if value != "" {
+		return value
+	}
+	return defaultVal
+}
+
+// Page selects a page of a listing. The zero Page is the first page in ID
+// order with the API's default size. Sort takes "id" or an order the
+// listing supports, prefixed with "-" for descending order.
+type Page struct {
+	Limit  int
+	Cursor string // Next cursor returned with the previous page
+	Sort   string
+}
+
+func (p Page) query() url.Values {
+	query := url.Values{}
+	if p.Limit > 0 {
+		query.Set("limit", strconv.Itoa(p.Limit))
+	}
+	set(query, "cursor", p.Cursor)
+	set(query, "sort", p.Sort)
+	return query
+}
+
+// set adds a query param unless its value is empty
+func set(query url.Values, name string, value string) {
+	if value != "" {
+		query.Set(name, value)
+	}
+}
+
+// do sends a request with in, if not nil, as its JSON body and decodes a
+// successful response into out, if not nil. It returns the response
+// headers, e.g. for the next cursor of a listing.
+func (c *Client) do(ctx context.Context, method string, rawURL string, query url.Values, in interface{}, out interface{}) (http.Header, error) {
+	if len(query) > 0 {
+		rawURL += "?" + query.Encode()
+	}
+
+	var inJSON []byte
+	if in != nil {
+		var err error
+		if inJSON, err = json.Marshal(in); err != nil {
+			return nil, err
+		}
+	}
+
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		header, retry, err := c.attempt(ctx, method, rawURL, inJSON, out)
+		if !retry || !idempotent(method) || attempt == retryAttempts {
+			return header, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends the request once. retry reports whether it failed in a way
+// another attempt may not.
+func (c *Client) attempt(ctx context.Context, method string, rawURL string, inJSON []byte, out interface{}) (header http.Header, retry bool, err error) {
+	var body io.Reader
+	if inJSON != nil {
+		body = bytes.NewReader(inJSON)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, false, err
+	}
+	if inJSON != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(request)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, resp.StatusCode >= http.StatusInternalServerError, newError(method, rawURL, resp.StatusCode, respBody)
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return nil, false, fmt.Errorf("reading the response to %s %s: %w", method, rawURL, err)
+		}
+	}
+	return resp.Header, false, nil
+}
+
+// idempotent reports whether a request with the method may be sent again
+// without changing its outcome
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// list GETs a page of a listing into out and returns the next cursor, ""
+// after the last page
+func (c *Client) list(ctx context.Context, rawURL string, query url.Values, page Page, out interface{}) (string, error) {
+	for name, values := range page.query() {
+		query[name] = values
+	}
+	header, err := c.do(ctx, http.MethodGet, rawURL, query, nil, out)
+	if err != nil {
+		return "", err
+	}
+	return header.Get("X-Next-Cursor"), nil
+}
@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/AmiraliSajadi/Votin-System-APIs/client/schema"
+)
+
+// newTestClient points every API of a Client at handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return New(Config{PollAPIURL: server.URL, VoterAPIURL: server.URL, VotesAPIURL: server.URL})
+}
+
+func sendProblem(w http.ResponseWriter, status int, problem string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	fmt.Fprint(w, problem)
+}
+
+// TestErrors checks that error responses come back as an *Error matching
+// the sentinel for their status, with the problem document's details
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		is      error
+		code    string
+		message string
+		fields  []FieldError
+	}{
+		{
+			status:  http.StatusBadRequest,
+			body:    `{"title":"Bad Request","status":400,"detail":"Invalid poll","code":"validation_failed","errors":[{"field":"PollTitle","reason":"Must not be blank."}]}`,
+			is:      ErrInvalid,
+			code:    "validation_failed",
+			message: "Invalid poll",
+			fields:  []FieldError{{Field: "PollTitle", Reason: "Must not be blank."}},
+		},
+		{
+			status:  http.StatusNotFound,
+			body:    `{"title":"Not Found","status":404,"code":"not_found"}`,
+			is:      ErrNotFound,
+			code:    "not_found",
+			message: "Not Found",
+		},
+		{
+			status:  http.StatusConflict,
+			body:    `{"title":"Conflict","status":409,"detail":"Voter 1 already voted in poll 1","code":"conflict"}`,
+			is:      ErrConflict,
+			code:    "conflict",
+			message: "Voter 1 already voted in poll 1",
+		},
+		{
+			status:  http.StatusBadGateway,
+			body:    `{"title":"Bad Gateway","status":502,"detail":"Could not check the poll","code":"dependency_failed"}`,
+			is:      ErrUnavailable,
+			code:    "dependency_failed",
+			message: "Could not check the poll",
+		},
+		{
+			// Not a problem document, e.g. from a proxy in front of the API
+			status:  http.StatusServiceUnavailable,
+			body:    `<html>Service Unavailable</html>`,
+			is:      ErrUnavailable,
+			message: "Service Unavailable",
+		},
+	}
+
+	for _, test := range tests {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sendProblem(w, test.status, test.body)
+		})
+		_, err := c.CastVote(context.Background(), schema.Vote{PollID: "1", VoterID: "1", VoteValue: 1})
+
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			t.Errorf("%d: got %v, expected an *Error", test.status, err)
+			continue
+		}
+		if !errors.Is(err, test.is) {
+			t.Errorf("%d: %v does not match %v", test.status, err, test.is)
+		}
+		for _, other := range []error{ErrNotFound, ErrConflict, ErrInvalid, ErrUnavailable} {
+			if other != test.is && errors.Is(err, other) {
+				t.Errorf("%d: %v also matches %v", test.status, err, other)
+			}
+		}
+		if apiErr.StatusCode != test.status || apiErr.Code != test.code || apiErr.Message != test.message {
+			t.Errorf("%d: got status %d, code %q and message %q, expected %d, %q and %q",
+				test.status, apiErr.StatusCode, apiErr.Code, apiErr.Message, test.status, test.code, test.message)
+		}
+		if len(apiErr.Fields) != len(test.fields) || (len(test.fields) > 0 && apiErr.Fields[0] != test.fields[0]) {
+			t.Errorf("%d: got fields %v, expected %v", test.status, apiErr.Fields, test.fields)
+		}
+	}
+}
+
+// TestListPaging follows X-Next-Cursor through a listing of 5 polls in
+// pages of 2
+func TestListPaging(t *testing.T) {
+	const total = 5
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/polls" || r.URL.Query().Get("limit") != "2" || r.URL.Query().Get("status") != "open" {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		first := 1
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			first, _ = strconv.Atoi(cursor)
+		}
+		var page []schema.Poll
+		for id := first; id < first+2 && id <= total; id++ {
+			page = append(page, schema.Poll{PollID: uint(id), PollStatus: schema.PollStatusOpen})
+		}
+		if first+2 <= total {
+			w.Header().Set("X-Next-Cursor", strconv.Itoa(first+2))
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+
+	var ids []uint
+	page := Page{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > total {
+			t.Fatal("The listing did not end")
+		}
+		polls, next, err := c.ListPolls(context.Background(), PollFilter{Status: schema.PollStatusOpen}, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, poll := range polls {
+			ids = append(ids, poll.PollID)
+		}
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+
+	if fmt.Sprint(ids) != "[1 2 3 4 5]" {
+		t.Errorf("Listed polls %v, expected [1 2 3 4 5]", ids)
+	}
+}
+
+// TestRetries checks that GET, PUT and DELETE requests are retried after a
+// 5xx and other requests are not
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+
+	// failing is how many requests in a row get a 503 before one succeeds
+	var failing, requests int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= atomic.LoadInt32(&failing) {
+			sendProblem(w, http.StatusServiceUnavailable, `{"status":503,"code":"dependency_unavailable"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(schema.Poll{PollID: 1})
+	})
+	reset := func(fail int32) {
+		atomic.StoreInt32(&failing, fail)
+		atomic.StoreInt32(&requests, 0)
+	}
+
+	reset(retryAttempts - 1)
+	if poll, err := c.GetPoll(ctx, 1); err != nil || poll.PollID != 1 {
+		t.Errorf("GET after %d failures: got %v, %v", retryAttempts-1, poll, err)
+	}
+	if got := atomic.LoadInt32(&requests); got != retryAttempts {
+		t.Errorf("GET was sent %d times, expected %d", got, retryAttempts)
+	}
+
+	reset(retryAttempts)
+	if err := c.DeletePoll(ctx, 1, DeletePollOptions{}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("DELETE after %d failures: got %v, expected ErrUnavailable", retryAttempts, err)
+	}
+	if got := atomic.LoadInt32(&requests); got != retryAttempts {
+		t.Errorf("DELETE was sent %d times, expected %d", got, retryAttempts)
+	}
+
+	reset(1)
+	if _, err := c.CreatePoll(ctx, schema.Poll{PollTitle: "Once"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("POST after a failure: got %v, expected ErrUnavailable", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("POST was sent %d times, expected once", got)
+	}
+
+	// Errors other than 5xx are the answer and are not retried
+	var conflicts int32
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&conflicts, 1)
+		sendProblem(w, http.StatusConflict, `{"status":409,"code":"conflict"}`)
+	})
+	if _, err := c.ReplacePoll(ctx, schema.Poll{PollID: 1}); !errors.Is(err, ErrConflict) {
+		t.Errorf("PUT answered 409: got %v, expected ErrConflict", err)
+	}
+	if got := atomic.LoadInt32(&conflicts); got != 1 {
+		t.Errorf("PUT answered 409 was sent %d times, expected once", got)
+	}
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors to match an *Error against with errors.Is
+var (
+	ErrNotFound    = errors.New("not found")           // 404
+	ErrConflict    = errors.New("conflict")            // 409, e.g. a voter who already voted in the poll
+	ErrInvalid     = errors.New("invalid request")     // 400
+	ErrUnavailable = errors.New("service unavailable") // 502 and 503, another API the call needed failed
+)
+
+// Error is an error response from one of the APIs, which answer with an
+// RFC 7807 problem document
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Code       string       // e.g. "not_found" or "validation_failed", "" if the body was not a problem document
+	Message    string       // What the API said went wrong
+	Fields     []FieldError // The invalid fields of the request, if the API named them
+}
+
+// FieldError says what is wrong with one field of a request
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func newError(method string, url string, statusCode int, body []byte) *Error {
+	e := &Error{Method: method, URL: url, StatusCode: statusCode, Message: http.StatusText(statusCode)}
+	var problem struct {
+		Title  string       `json:"title"`
+		Detail string       `json:"detail"`
+		Code   string       `json:"code"`
+		Errors []FieldError `json:"errors"`
+	}
+	if json.Unmarshal(body, &problem) != nil {
+		return e
+	}
+	e.Code, e.Fields = problem.Code, problem.Errors
+	if problem.Detail != "" {
+		e.Message = problem.Detail
+	} else if problem.Title != "" {
+		e.Message = problem.Title
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrInvalid:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusBadGateway || e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
@@ -0,0 +1,3 @@
+module github.com/AmiraliSajadi/Votin-System-APIs/client
+
+go 1.20
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/AmiraliSajadi/Votin-System-APIs/client/schema"
+)
+
+// PollFilter narrows ListPolls. Zero fields match every poll.
+type PollFilter struct {
+	Status schema.PollStatus
+	Title  string // Case-insensitive part of the title
+}
+
+// ListPolls returns a page of polls and the cursor for the next page. Sort
+// takes "id" or "title".
+func (c *Client) ListPolls(ctx context.Context, filter PollFilter, page Page) ([]schema.Poll, string, error) {
+	query := url.Values{}
+	set(query, "status", string(filter.Status))
+	set(query, "title", filter.Title)
+
+	var polls []schema.Poll
+	next, err := c.list(ctx, c.pollAPI+"/polls", query, page, &polls)
+	return polls, next, err
+}
+
+func (c *Client) GetPoll(ctx context.Context, id uint) (schema.Poll, error) {
+	var poll schema.Poll
+	_, err := c.do(ctx, http.MethodGet, c.pollURL(id), nil, nil, &poll)
+	return poll, err
+}
+
+// CreatePoll stores a new poll, as a draft unless its PollStatus is "open".
+// A zero PollID lets the API pick one; the poll is returned as stored.
+func (c *Client) CreatePoll(ctx context.Context, poll schema.Poll) (schema.Poll, error) {
+	var created schema.Poll
+	_, err := c.do(ctx, http.MethodPost, c.pollAPI+"/polls", nil, poll, &created)
+	return created, err
+}
+
+// ReplacePoll replaces the poll with the same PollID
+func (c *Client) ReplacePoll(ctx context.Context, poll schema.Poll) (schema.Poll, error) {
+	var replaced schema.Poll
+	_, err := c.do(ctx, http.MethodPut, c.pollURL(poll.PollID), nil, poll, &replaced)
+	return replaced, err
+}
+
+// PatchPoll applies a JSON Merge Patch, e.g.
+// map[string]interface{}{"PollTitle": "New title"}, to the poll
+func (c *Client) PatchPoll(ctx context.Context, id uint, patch map[string]interface{}) (schema.Poll, error) {
+	var patched schema.Poll
+	_, err := c.do(ctx, http.MethodPatch, c.pollURL(id), nil, patch, &patched)
+	return patched, err
+}
+
+// DeletePollOptions say what happens to a poll that has votes. Without
+// either, deleting such a poll fails with ErrConflict.
+type DeletePollOptions struct {
+	Cascade bool // Delete the poll's votes too
+	Archive bool // Archive the poll instead, whatever its status, keeping its votes
+}
+
+func (c *Client) DeletePoll(ctx context.Context, id uint, options DeletePollOptions) error {
+	query := url.Values{}
+	if options.Cascade {
+		query.Set("cascade", "true")
+	}
+	if options.Archive {
+		query.Set("archive", "true")
+	}
+	_, err := c.do(ctx, http.MethodDelete, c.pollURL(id), query, nil, nil)
+	return err
+}
+
+// OpenPoll, ClosePoll and ArchivePoll move the poll through its statuses
+func (c *Client) OpenPoll(ctx context.Context, id uint) (schema.Poll, error) {
+	return c.transitionPoll(ctx, id, "open")
+}
+
+func (c *Client) ClosePoll(ctx context.Context, id uint) (schema.Poll, error) {
+	return c.transitionPoll(ctx, id, "close")
+}
+
+func (c *Client) ArchivePoll(ctx context.Context, id uint) (schema.Poll, error) {
+	return c.transitionPoll(ctx, id, "archive")
+}
+
+func (c *Client) transitionPoll(ctx context.Context, id uint, action string) (schema.Poll, error) {
+	var poll schema.Poll
+	_, err := c.do(ctx, http.MethodPost, c.pollURL(id)+"/"+action, nil, nil, &poll)
+	return poll, err
+}
+
+func (c *Client) pollURL(id uint) string {
+	return fmt.Sprintf("%s/polls/%d", c.pollAPI, id)
+}
@@ -0,0 +1,56 @@
+// Package schema mirrors the request and response types of the poll, voter
+// and votes APIs, as they are sent over the wire. The APIs check the rules
+// on them, e.g. that a poll has a title and at least one option.
+package schema
+
+import "time"
+
+// Poll mirrors poll-api's schema.Poll
+
+type PollStatus string
+
+const (
+	PollStatusDraft    PollStatus = "draft"
+	PollStatusOpen     PollStatus = "open"
+	PollStatusClosed   PollStatus = "closed"
+	PollStatusArchived PollStatus = "archived"
+)
+
+type PollType string
+
+const (
+	PollTypeSingle   PollType = "single"   // One option per vote (the default)
+	PollTypeRanked   PollType = "ranked"   // Ordered preferences, decided by instant-runoff
+	PollTypeApproval PollType = "approval" // Any number of options, within optional limits
+	PollTypeMulti    PollType = "multi"    // Up to MaxSelections options
+	PollTypeScore    PollType = "score"    // Every option rated between MinScore and MaxScore
+)
+
+// Score polls without an explicit range are rated 0-5
+const DefaultMaxScore = 5
+
+type PollOption struct {
+	PollOptionID   uint
+	PollOptionText string
+}
+
+type Poll struct {
+	PollID       uint
+	PollTitle    string
+	PollQuestion string
+	PollOptions  []PollOption
+	PollStatus   PollStatus
+	PollType     PollType
+	// Approval and multi-select polls only: how many options a vote may
+	// select. Zero means no lower bound beyond one / no upper bound.
+	MinSelections uint
+	MaxSelections uint
+	// Score polls only: the range each option is rated in
+	MinScore uint
+	MaxScore uint
+	OpensAt  *time.Time // Optional: the scheduler opens a draft poll at this time
+	ClosesAt *time.Time // Optional: the scheduler closes an open poll at this time
+	// How many seconds after it is cast a vote may still be changed or
+	// retracted while the poll is open. Zero makes votes final.
+	VoteChangeSeconds uint
+}
@@ -0,0 +1,48 @@
+package schema
+
+// PollResults mirrors votes-api's schema.PollResults
+
+type ScoreCount struct {
+	Score uint
+	Count int64
+}
+
+// ScoreSummary describes the ratings an option received in a score poll
+type ScoreSummary struct {
+	Mean         float64
+	Median       float64
+	Distribution []ScoreCount
+}
+
+type OptionResult struct {
+	PollOptionID   uint
+	PollOptionText string
+	Votes          int64 // For score polls, the number of ratings
+	Percentage     float64
+	Scores         *ScoreSummary // Score polls only
+}
+
+// RunoffRound is one counting round of an instant-runoff poll. Counts only
+// lists the options still in the race.
+type RunoffRound struct {
+	Round      int
+	Counts     []OptionResult
+	Exhausted  int64 // Ballots with no remaining preferences
+	Eliminated []uint
+}
+
+// PollVoteCount is how many votes a poll has, including the ones still being
+// cast
+type PollVoteCount struct {
+	PollID  string
+	Votes   int64 // Committed votes
+	Pending int   // Votes still being cast
+}
+
+type PollResults struct {
+	PollID     string
+	TotalVotes int64
+	Options    []OptionResult
+	Rounds     []RunoffRound // Ranked polls only
+	Winner     *uint         // Ranked polls only
+}
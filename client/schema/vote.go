@@ -0,0 +1,42 @@
+package schema
+
+import "time"
+
+// Vote mirrors votes-api's schema.Vote
+
+type OptionScore struct {
+	PollOptionID uint
+	Score        uint
+}
+
+// Whether the ballot fits its poll is checked against the poll
+type Vote struct {
+	VoteID    uint
+	VoterID   string // e.g. "1" or "/voters/1"
+	PollID    string // e.g. "1" or "/polls/1"
+	VoteValue uint
+	// Ranked polls only: PollOptionIDs in order of preference. VoteValue is
+	// set to the first preference.
+	VoteRanking []uint
+	// Approval and multi-select polls only: the PollOptionIDs selected
+	VoteSelections []uint
+	// Score polls only: a rating for each option the voter scored
+	VoteScores []OptionScore
+	CastAt     *time.Time // Set by the server when the vote is submitted
+}
+
+type VoteAction string
+
+const (
+	VoteChanged   VoteAction = "changed"
+	VoteRetracted VoteAction = "retracted"
+)
+
+// VoteAudit records a change made to a vote after it was cast, with the
+// vote as it was before
+type VoteAudit struct {
+	VoteID   uint
+	Action   VoteAction
+	At       time.Time
+	Previous Vote
+}
@@ -0,0 +1,22 @@
+package schema
+
+import "time"
+
+// Voter mirrors voter-api's schema.Voter
+
+// VoteHistoryEntry records a vote the voter cast
+type VoteHistoryEntry struct {
+	PollLink string     // The poll the vote was cast in, e.g. "/polls/1"
+	VoteLink string     // The vote itself, e.g. "/votes/1"
+	VoteDate *time.Time // When the vote was cast
+	// The PollOptionIDs the vote chose: the option voted for, the ranking
+	// in order of preference, the selected or the rated options
+	Options []uint
+}
+
+type Voter struct {
+	VoterID     uint
+	FirstName   string
+	LastName    string
+	VoteHistory []VoteHistoryEntry
+}
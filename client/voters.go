@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/AmiraliSajadi/Votin-System-APIs/client/schema"
+)
+
+// VoterFilter narrows ListVoters. Zero fields match every voter.
+type VoterFilter struct {
+	LastName  string // Case-insensitive prefix of LastName
+	FirstName string // Case-insensitive prefix of FirstName
+	VotedIn   uint   // ID of a poll the voter has voted in
+}
+
+// ListVoters returns a page of voters and the cursor for the next page.
+// Sort takes "id" or "name".
+func (c *Client) ListVoters(ctx context.Context, filter VoterFilter, page Page) ([]schema.Voter, string, error) {
+	query := url.Values{}
+	set(query, "lastName", filter.LastName)
+	set(query, "firstName", filter.FirstName)
+	if filter.VotedIn != 0 {
+		query.Set("votedIn", fmt.Sprint(filter.VotedIn))
+	}
+
+	var voters []schema.Voter
+	next, err := c.list(ctx, c.voterAPI+"/voters", query, page, &voters)
+	return voters, next, err
+}
+
+func (c *Client) GetVoter(ctx context.Context, id uint) (schema.Voter, error) {
+	var voter schema.Voter
+	_, err := c.do(ctx, http.MethodGet, c.voterURL(id), nil, nil, &voter)
+	return voter, err
+}
+
+// CreateVoter stores a new voter. A zero VoterID lets the API pick one; the
+// voter is returned as stored.
+func (c *Client) CreateVoter(ctx context.Context, voter schema.Voter) (schema.Voter, error) {
+	var created schema.Voter
+	_, err := c.do(ctx, http.MethodPost, c.voterAPI+"/voters", nil, voter, &created)
+	return created, err
+}
+
+// ReplaceVoter replaces the voter with the same VoterID. A nil VoteHistory
+// keeps the stored one.
+func (c *Client) ReplaceVoter(ctx context.Context, voter schema.Voter) (schema.Voter, error) {
+	var replaced schema.Voter
+	_, err := c.do(ctx, http.MethodPut, c.voterURL(voter.VoterID), nil, voter, &replaced)
+	return replaced, err
+}
+
+// PatchVoter applies a JSON Merge Patch, e.g.
+// map[string]interface{}{"LastName": "Smith"}, to the voter
+func (c *Client) PatchVoter(ctx context.Context, id uint, patch map[string]interface{}) (schema.Voter, error) {
+	var patched schema.Voter
+	_, err := c.do(ctx, http.MethodPatch, c.voterURL(id), nil, patch, &patched)
+	return patched, err
+}
+
+// DeleteVoter deletes a voter who never voted. With anonymize their votes
+// are kept without the voter; otherwise deleting a voter with votes fails
+// with ErrConflict.
+func (c *Client) DeleteVoter(ctx context.Context, id uint, anonymize bool) error {
+	query := url.Values{}
+	if anonymize {
+		query.Set("anonymize", "true")
+	}
+	_, err := c.do(ctx, http.MethodDelete, c.voterURL(id), query, nil, nil)
+	return err
+}
+
+// VoteHistory returns the entries of the votes the voter cast
+func (c *Client) VoteHistory(ctx context.Context, id uint) ([]schema.VoteHistoryEntry, error) {
+	var history []schema.VoteHistoryEntry
+	_, err := c.do(ctx, http.MethodGet, c.voterURL(id)+"/history", nil, nil, &history)
+	return history, err
+}
+
+// AddToVoteHistory appends an entry to the voter's VoteHistory. votes-api
+// does this when a vote is cast; an entry for the same VoteLink fails with
+// ErrConflict.
+func (c *Client) AddToVoteHistory(ctx context.Context, id uint, entry schema.VoteHistoryEntry) error {
+	_, err := c.do(ctx, http.MethodPut, c.voterURL(id)+"/history", nil, entry, nil)
+	return err
+}
+
+// UpdateVoteHistoryEntry replaces the entry for the vote, returning the
+// updated VoteHistory
+func (c *Client) UpdateVoteHistoryEntry(ctx context.Context, id uint, voteID uint, entry schema.VoteHistoryEntry) ([]schema.VoteHistoryEntry, error) {
+	var history []schema.VoteHistoryEntry
+	_, err := c.do(ctx, http.MethodPut, fmt.Sprintf("%s/history/%d", c.voterURL(id), voteID), nil, entry, &history)
+	return history, err
+}
+
+// RemoveFromVoteHistory takes the entry for the vote out of the voter's
+// VoteHistory
+func (c *Client) RemoveFromVoteHistory(ctx context.Context, id uint, voteID uint) error {
+	_, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("%s/history/%d", c.voterURL(id), voteID), nil, nil, nil)
+	return err
+}
+
+func (c *Client) voterURL(id uint) string {
+	return fmt.Sprintf("%s/voters/%d", c.voterAPI, id)
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/AmiraliSajadi/Votin-System-APIs/client/schema"
+)
+
+// VoteFilter narrows ListVotes. Zero fields match every vote.
+type VoteFilter struct {
+	PollID   uint
+	VoterID  uint
+	OptionID uint // Votes choosing this option; needs a PollID
+	From     time.Time
+	To       time.Time
+}
+
+// ListVotes returns a page of votes and the cursor for the next page. Sort
+// takes "id" or "time".
+func (c *Client) ListVotes(ctx context.Context, filter VoteFilter, page Page) ([]schema.Vote, string, error) {
+	query := url.Values{}
+	for name, id := range map[string]uint{"poll": filter.PollID, "voter": filter.VoterID, "option": filter.OptionID} {
+		if id != 0 {
+			query.Set(name, fmt.Sprint(id))
+		}
+	}
+	for name, t := range map[string]time.Time{"from": filter.From, "to": filter.To} {
+		if !t.IsZero() {
+			query.Set(name, t.Format(time.RFC3339))
+		}
+	}
+
+	var votes []schema.Vote
+	next, err := c.list(ctx, c.votesAPI+"/votes", query, page, &votes)
+	return votes, next, err
+}
+
+func (c *Client) GetVote(ctx context.Context, id uint) (schema.Vote, error) {
+	var vote schema.Vote
+	_, err := c.do(ctx, http.MethodGet, c.voteURL(id), nil, nil, &vote)
+	return vote, err
+}
+
+// CastVote stores a vote in an open poll. VoterID and PollID take an ID
+// like "1" or a link like "/polls/1", and the ballot field that fits the
+// poll's type must be set. A voter who already voted in the poll gets
+// ErrConflict.
+func (c *Client) CastVote(ctx context.Context, vote schema.Vote) (schema.Vote, error) {
+	var cast schema.Vote
+	_, err := c.do(ctx, http.MethodPost, c.votesAPI+"/votes", nil, vote, &cast)
+	return cast, err
+}
+
+// ChangeVote replaces the ballot of the vote with the same VoteID, within
+// the poll's VoteChangeSeconds. Votes that can no longer change fail with
+// ErrConflict.
+func (c *Client) ChangeVote(ctx context.Context, vote schema.Vote) (schema.Vote, error) {
+	var changed schema.Vote
+	_, err := c.do(ctx, http.MethodPut, c.voteURL(vote.VoteID), nil, vote, &changed)
+	return changed, err
+}
+
+// RetractVote deletes a vote that can still change, giving the voter their
+// ballot back
+func (c *Client) RetractVote(ctx context.Context, id uint) error {
+	_, err := c.do(ctx, http.MethodDelete, c.voteURL(id), nil, nil, nil)
+	return err
+}
+
+// VoteAudit lists the changes made to the vote, oldest first
+func (c *Client) VoteAudit(ctx context.Context, id uint) ([]schema.VoteAudit, error) {
+	var trail []schema.VoteAudit
+	_, err := c.do(ctx, http.MethodGet, c.voteURL(id)+"/audit", nil, nil, &trail)
+	return trail, err
+}
+
+// PollResults counts the votes cast in the poll
+func (c *Client) PollResults(ctx context.Context, pollID uint) (schema.PollResults, error) {
+	var results schema.PollResults
+	_, err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/polls/%d/results", c.votesAPI, pollID), nil, nil, &results)
+	return results, err
+}
+
+// CountPollVotes counts the votes in the poll, including the ones still
+// being cast. poll-api asks before it deletes a poll or changes its options.
+func (c *Client) CountPollVotes(ctx context.Context, pollID uint) (schema.PollVoteCount, error) {
+	var count schema.PollVoteCount
+	_, err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/polls/%d/votes", c.votesAPI, pollID), nil, nil, &count)
+	return count, err
+}
+
+// DeletePollVotes deletes every vote cast in the poll. poll-api does this
+// when a poll is deleted with cascade.
+func (c *Client) DeletePollVotes(ctx context.Context, pollID uint) error {
+	_, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("%s/polls/%d/votes", c.votesAPI, pollID), nil, nil, nil)
+	return err
+}
+
+// QueueAnonymizeVoter queues the voter to have their votes anonymized by
+// votes-api's reconciler once they are deleted. voter-api does this before
+// it deletes a voter with anonymize.
+func (c *Client) QueueAnonymizeVoter(ctx context.Context, voterID uint) error {
+	_, err := c.do(ctx, http.MethodPost, fmt.Sprintf("%s/voters/%d/anonymize-queue", c.votesAPI, voterID), nil, nil, nil)
+	return err
+}
+
+// AnonymizeVoterVotes detaches the voter from every vote they cast.
+// voter-api does this when a voter is deleted with anonymize.
+func (c *Client) AnonymizeVoterVotes(ctx context.Context, voterID uint) error {
+	_, err := c.do(ctx, http.MethodPost, fmt.Sprintf("%s/voters/%d/anonymize", c.votesAPI, voterID), nil, nil, nil)
+	return err
+}
+
+func (c *Client) voteURL(id uint) string {
+	return fmt.Sprintf("%s/votes/%d", c.votesAPI, id)
+}
@@ -13,6 +13,10 @@ sleep 5
 docker exec -it poll-api-1 curl -X POST -H "Content-Type: application/json" -d '{"PollID": 1,"PollTitle": "Color Poll","PollQuestion": "What is your favorite color?","PollOptions": [ { "PollOptionID": 1, "PollOptionText": "Red" },{ "PollOptionID": 2, "PollOptionText": "Blue" },{ "PollOptionID": 3, "PollOptionText": "Green" }] }' http://localhost:2080/polls
 docker exec -it poll-api-1 curl -X POST -H "Content-Type: application/json" -d '{"PollID": 2,"PollTitle": "Poll Question Poll","PollQuestion": "How much time did you spend coming up with an interesting poll question?","PollOptions": [ { "PollOptionID": 1, "PollOptionText": "< 60s" },{ "PollOptionID": 2, "PollOptionText": "1-3 minutes" },{ "PollOptionID": 3, "PollOptionText": "too much" }] }' http://localhost:2080/polls
 
+# Open both polls for voting (new polls start as drafts)
+docker exec -it poll-api-1 curl -X POST http://localhost:2080/polls/1/open
+docker exec -it poll-api-1 curl -X POST http://localhost:2080/polls/2/open
+
 # Add a voter
 docker exec -it voter-api-1 curl -X POST -H "Content-Type: application/json" -d '{"VoterID": 1,"FirstName": "John","LastName": "Doe","VoteHistory": []}' http://localhost:1080/voters
 docker exec -it voter-api-1 curl -X POST -H "Content-Type: application/json" -d '{"VoterID": 2,"FirstName": "Amirali","LastName": "Sajadi","VoteHistory": []}' http://localhost:1080/voters
@@ -0,0 +1,44 @@
+#!/bin/bash
+
+# Fires parallel PUTs at a voter's VoteHistory and checks that no entry is
+# lost and that a vote link is only ever added once, with either store
+# backend. Run it against a voter-api started on its own: the links are
+# made up, so votes-api's reconciler would take them out of the history
+# again.
+#
+#   ./history_concurrency.sh [number of votes] [voter-api URL]
+
+VOTES=${1:-300}
+VOTER_API=${2:-http://localhost:1080}
+
+# A fresh voter, so the history starts out empty
+VOTER=$(curl -s -X POST -H "Content-Type: application/json" -d '{"FirstName": "Concurrent","LastName": "Voter"}' \
+	-o /dev/null -w '%header{location}' $VOTER_API/voters)
+if [ -z "$VOTER" ]; then
+	echo "Could not create a voter at $VOTER_API"
+	exit 1
+fi
+echo "Testing $VOTER_API$VOTER/history with $VOTES votes"
+
+put_vote() {
+	curl -s -X PUT -H "Content-Type: application/json" -o /dev/null -w '%{http_code}\n' \
+		-d "{\"PollLink\": \"/polls/1\",\"VoteLink\": \"/votes/$1\"}" $VOTER_API$VOTER/history
+}
+export -f put_vote
+export VOTER_API VOTER
+
+# Every vote twice, all at once: one PUT per link must win with 200 and
+# the other must be refused with 409
+CODES=$( (seq 1 $VOTES; seq 1 $VOTES) | xargs -P 64 -I {} bash -c 'put_vote {}' | sort | uniq -c)
+echo "$CODES"
+
+ENTRIES=$(curl -s $VOTER_API$VOTER/history | grep -o '"VoteLink"' | wc -l)
+ADDED=$(echo "$CODES" | awk '$2 == 200 { print $1 }')
+CONFLICTS=$(echo "$CODES" | awk '$2 == 409 { print $1 }')
+
+if [ "$ENTRIES" -eq "$VOTES" ] && [ "${ADDED:-0}" -eq "$VOTES" ] && [ "${CONFLICTS:-0}" -eq "$VOTES" ]; then
+	echo "OK: $ENTRIES entries in the VoteHistory"
+else
+	echo "FAILED: $ENTRIES entries in the VoteHistory, $ADDED added and $CONFLICTS refused, expected $VOTES of each"
+	exit 1
+fi
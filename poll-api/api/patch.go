@@ -0,0 +1,24 @@
+package api
+
+// mergePatch applies a JSON Merge Patch (RFC 7396) to a decoded JSON
+// document: objects are merged key by key, null removes a key and anything
+// else replaces the target outright
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
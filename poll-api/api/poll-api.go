@@ -1,143 +1,564 @@
 package api
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"poll-api/schema"
+	"poll-api/store"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis/v8"
-	"github.com/nitishm/go-rejson/v4"
 )
 
-const (
-	RedisNilError        = "redis: nil"
-	RedisDefaultLocation = "0.0.0.0:6379"
+var (
+	errInvalidTransition = errors.New("invalid poll status transition")
+	errInvalidPoll       = errors.New("invalid poll")
+	errPollHasVotes      = errors.New("poll has votes")
+	errPollChanged       = errors.New("poll changed")
 )
 
-type cache struct {
-	client  *redis.Client
-	helper  *rejson.Handler
-	context context.Context
-}
-
 type PollAPI struct {
-	cache
+	polls    store.PollRepository
+	votesAPI *serviceClient
 }
 
-func NewPollAPI(location string) (*PollAPI, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr: location,
-	})
-
-	ctx := context.Background()
+func NewPollAPI(polls store.PollRepository, votesAPIURL string) *PollAPI {
+	return &PollAPI{
+		polls:    polls,
+		votesAPI: newServiceClient("votes-api", votesAPIURL),
+	}
+}
 
-	err := client.Ping(ctx).Err()
+// pollIDParam reads the :id param, responding with 400 if it is not a
+// valid poll ID
+func pollIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
-		log.Println("Error connecting to redis" + err.Error())
-		return nil, err
+		badRequest(c, "Invalid poll ID: "+c.Param("id"))
+		return 0, false
 	}
+	return uint(id), true
+}
 
-	jsonHelper := rejson.NewReJSONHandler()
-	jsonHelper.SetGoRedisClientWithContext(ctx, client)
+// pageParams reads the ?limit=&cursor=&sort= query params of a list
+// endpoint, responding with 400 if they are not valid. sort takes "id" or
+// one of the given orders, prefixed with "-" for descending order.
+func pageParams(c *gin.Context, sorts ...string) (store.Page, bool) {
+	page := store.Page{Cursor: c.Query("cursor"), Limit: store.DefaultPageLimit}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > store.MaxPageLimit {
+			msg := fmt.Sprintf("limit must be a number between 1 and %d", store.MaxPageLimit)
+			badRequest(c, msg)
+			return page, false
+		}
+		page.Limit = n
+	}
 
-	return &PollAPI{
-		cache: cache{
-			client:  client,
-			helper:  jsonHelper,
-			context: ctx,
-		},
-	}, nil
+	if sort := c.Query("sort"); sort != "" {
+		page.Desc = strings.HasPrefix(sort, "-")
+		page.Sort = strings.TrimPrefix(sort, "-")
+		valid := page.Sort == store.SortByID
+		for _, allowed := range sorts {
+			valid = valid || page.Sort == allowed
+		}
+		if !valid {
+			orders := strings.Join(append([]string{store.SortByID}, sorts...), ", ")
+			msg := fmt.Sprintf("sort must be one of %s, optionally prefixed with -", orders)
+			badRequest(c, msg)
+			return page, false
+		}
+	}
+	return page, true
+}
+
+// setNextCursor tells the client where the next page starts. The body stays
+// a plain list so existing clients keep working.
+func setNextCursor(c *gin.Context, next string) {
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+	}
 }
 
 func (p *PollAPI) GetPollByID(c *gin.Context) {
-	pubid := c.Param("id")
-	if pubid == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No publication ID provided"})
+	id, ok := pollIDParam(c)
+	if !ok {
 		return
 	}
 
-	var pollItem schema.Poll
-	value, err := p.client.Get(c, "poll-"+pubid).Result()
-	if err == redis.Nil {
-		msg := fmt.Sprintf("Key %s does not exist in Redis\n", pubid)
-		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+	pollItem, err := p.polls.Get(c, id)
+	if err == store.ErrNotFound {
+		msg := fmt.Sprintf("Poll %d does not exist", id)
+		notFound(c, msg)
 		return
 	} else if err != nil {
-		msg := fmt.Sprintf("Error getting key %s: %v", pubid, err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		log.Printf("Error getting poll %d: %v", id, err)
+		internalError(c, "Error getting poll")
 		return
-	} else {
-		valueBytes := []byte(value)
-		err = json.Unmarshal(valueBytes, &pollItem)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find publication in cache with id=" + pubid})
-		}
+	}
 
-		c.JSON(http.StatusOK, pollItem)
+	c.JSON(http.StatusOK, pollItem)
+}
+
+func (p *PollAPI) GetAllPolls(c *gin.Context) {
+	page, ok := pageParams(c, store.SortByTitle)
+	if !ok {
+		return
+	}
+
+	filter := store.PollFilter{
+		Status: schema.PollStatus(c.Query("status")),
+		Title:  c.Query("title"),
+	}
+	if filter.Status != "" && !filter.Status.IsValid() {
+		badRequest(c, "Invalid poll status: "+string(filter.Status))
+		return
+	}
+
+	pollList, next, err := p.polls.List(c, filter, page)
+	if err == store.ErrInvalidCursor {
+		badRequest(c, "Invalid cursor: "+page.Cursor)
+		return
+	} else if err != nil {
+		log.Println("Error listing polls: " + err.Error())
+		internalError(c, "Could not list polls")
 		return
 	}
+
+	setNextCursor(c, next)
+	c.JSON(http.StatusOK, pollList)
 }
 
-func (p *PollAPI) GetAllPolls(c *gin.Context) {
-	var pollList []schema.Poll
-	var pollItem schema.Poll
+func (p *PollAPI) PostPoll(c *gin.Context) {
+	var newPoll schema.Poll
 
-	//Lets query redis for all of the items
-	pattern := "poll-*"
-	ks, _ := p.client.Keys(p.context, pattern).Result()
-	for _, key := range ks {
+	if !bindJSON(c, &newPoll) {
+		return
+	}
 
-		value, err := p.client.Get(c, key).Result()
-		if err == redis.Nil {
-			fmt.Printf("Key %s does not exist in Redis\n", key)
-		} else if err != nil {
-			log.Fatalf("Error getting key %s: %v", key, err)
-		} else {
-			fmt.Printf("Value for key %s: %s\n", key, value)
-			valueBytes := []byte(value)
-			err = json.Unmarshal(valueBytes, &pollItem)
+	// New polls start as drafts unless the client opens them right away.
+	// Later states are only reached through the lifecycle.
+	if newPoll.PollStatus == "" {
+		newPoll.PollStatus = schema.PollStatusDraft
+	}
+	if newPoll.PollStatus != schema.PollStatusDraft && newPoll.PollStatus != schema.PollStatusOpen {
+		problem := newProblem(c, http.StatusBadRequest, codeValidationFailed, "The body has invalid fields")
+		problem.Errors = []FieldError{{Field: "PollStatus", Reason: "must be draft or open for a new poll"}}
+		sendProblem(c, http.StatusBadRequest, problem)
+		return
+	}
+	if msg := checkPoll(&newPoll); msg != "" {
+		badRequest(c, msg)
+		return
+	}
+
+	// Polls posted without a PollID get the next free one. IDs clients
+	// picked themselves are skipped.
+	allocate := newPoll.PollID == 0
+	for {
+		if allocate {
+			id, err := p.polls.NextID(c)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find publication in cache with id=" + key})
+				internalError(c, "Failed to allocate a poll ID")
+				return
 			}
+			newPoll.PollID = id
+		}
 
-			pollList = append(pollList, pollItem)
+		err := p.polls.Create(c, newPoll)
+		if err == store.ErrExists && allocate {
+			continue
+		} else if err == store.ErrExists {
+			conflict(c, fmt.Sprintf("Poll %d already exists", newPoll.PollID))
+			return
+		} else if err != nil {
+			internalError(c, "Failed to store poll in cache")
+			return
 		}
+		break
+	}
 
+	c.Header("Location", fmt.Sprintf("/polls/%d", newPoll.PollID))
+	c.JSON(http.StatusCreated, newPoll)
+}
+
+// checkPoll fills in the default type of a new or replaced poll and reports
+// the first problem the binding rules of schema.Poll do not catch, or "" if
+// it is valid
+func checkPoll(poll *schema.Poll) string {
+	if poll.PollType == "" {
+		poll.PollType = schema.PollTypeSingle
 	}
 
-	c.JSON(http.StatusOK, pollList)
+	if msg := poll.CheckSelectionLimits(); msg != "" {
+		return msg
+	}
+	if msg := poll.CheckScoreRange(); msg != "" {
+		return msg
+	}
+
+	if poll.OpensAt != nil && poll.ClosesAt != nil && !poll.ClosesAt.After(*poll.OpensAt) {
+		return "ClosesAt must be after OpensAt"
+	}
+	return ""
 }
 
-func (p *PollAPI) PostPoll(c *gin.Context) {
+// PutPoll replaces the poll in the :id param with the request body
+func (p *PollAPI) PutPoll(c *gin.Context) {
+	id, ok := pollIDParam(c)
+	if !ok {
+		return
+	}
+
 	var newPoll schema.Poll
+	if !bindJSON(c, &newPoll) {
+		return
+	}
+
+	p.replacePoll(c, id, func(current schema.Poll) (schema.Poll, error) {
+		return newPoll, nil
+	})
+}
+
+// PatchPoll applies the JSON Merge Patch in the request body to the poll in
+// the :id param
+func (p *PollAPI) PatchPoll(c *gin.Context) {
+	id, ok := pollIDParam(c)
+	if !ok {
+		return
+	}
+
+	var patch interface{}
+	if err := json.NewDecoder(c.Request.Body).Decode(&patch); err != nil {
+		badRequest(c, "The body must be a JSON Merge Patch: "+err.Error())
+		return
+	}
+	if _, ok := patch.(map[string]interface{}); !ok {
+		badRequest(c, "The body must be a JSON object")
+		return
+	}
+
+	p.replacePoll(c, id, func(current schema.Poll) (schema.Poll, error) {
+		var document interface{}
+		currentJSON, _ := json.Marshal(current)
+		if err := json.Unmarshal(currentJSON, &document); err != nil {
+			return current, err
+		}
+
+		var patched schema.Poll
+		patchedJSON, _ := json.Marshal(mergePatch(document, patch))
+		if err := json.Unmarshal(patchedJSON, &patched); err != nil {
+			return current, fmt.Errorf("%w: %v", errInvalidPoll, err)
+		}
+		return patched, validate(patched)
+	})
+}
+
+// replacePoll stores the version of the poll build makes out of the current
+// one. Once votes were cast in the poll its options cannot be removed and
+// its type cannot change, so the votes keep counting.
+//
+// Such a change is only stored after the votes are counted. The poll is
+// held closed meanwhile: votes-api reads the poll again before it commits a
+// vote, so none comes in between.
+func (p *PollAPI) replacePoll(c *gin.Context, id uint, build func(current schema.Poll) (schema.Poll, error)) {
+	current, err := p.polls.Get(c, id)
+	var next schema.Poll
+	if err == nil {
+		next, err = nextPoll(current, id, build)
+	}
+	if replaceFailed(c, id, err) {
+		return
+	}
+
+	keptErr := checkVotesKept(current, next)
+	counted, heldFrom := false, schema.PollStatus("")
+	if keptErr != nil {
+		heldFrom, err = p.holdClosed(c, id)
+		if replaceFailed(c, id, err) {
+			return
+		}
+		hasVotes, err := p.hasVotes(c.Request.Context(), id)
+		if err != nil || hasVotes {
+			if !p.releaseHold(c, id, heldFrom) {
+				return
+			}
+			if err != nil {
+				log.Printf("Error checking the votes of poll %d: %v", id, err)
+				dependencyError(c, err, "Could not check the poll's votes")
+			} else {
+				conflict(c, keptErr.Error())
+			}
+			return
+		}
+		counted = true
+	}
 
-	if err := c.ShouldBindJSON(&newPoll); err != nil {
-		log.Println("Error binding JSON: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+	pollItem, err := p.polls.Update(c, id, func(poll *schema.Poll) error {
+		if heldFrom != "" && poll.PollStatus == schema.PollStatusClosed {
+			poll.PollStatus = heldFrom
+		}
+		next, err := nextPoll(*poll, id, build)
+		if err != nil {
+			return err
+		}
+		if err := checkVotesKept(*poll, next); err != nil && !counted {
+			// The poll changed since it was read; the votes were not counted
+			return fmt.Errorf("%w: the poll changed meanwhile, repeat the request", errPollChanged)
+		}
+		*poll = next
+		return nil
+	})
+	if err != nil && !p.releaseHold(c, id, heldFrom) {
 		return
 	}
+	if replaceFailed(c, id, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, pollItem)
+}
 
-	pollJSON, err := json.Marshal(newPoll)
+// nextPoll is the version of the poll build makes out of current, checked
+// as replacePoll stores it
+func nextPoll(current schema.Poll, id uint, build func(current schema.Poll) (schema.Poll, error)) (schema.Poll, error) {
+	next, err := build(current)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize poll data"})
+		return next, err
+	}
+
+	if next.PollID == 0 {
+		next.PollID = id
+	} else if next.PollID != id {
+		return next, fmt.Errorf("%w: PollID cannot change", errInvalidPoll)
+	}
+	if next.PollStatus == "" {
+		next.PollStatus = current.PollStatus
+	}
+	if msg := checkPoll(&next); msg != "" {
+		return next, fmt.Errorf("%w: %s", errInvalidPoll, msg)
+	}
+	if next.PollStatus != current.PollStatus && !current.PollStatus.CanTransitionTo(next.PollStatus) {
+		return next, fmt.Errorf("%w: cannot move poll from %s to %s", errInvalidTransition, current.PollStatus, next.PollStatus)
+	}
+	return next, nil
+}
+
+// replaceFailed answers for err from storing a new version of the poll and
+// reports whether there was one
+func replaceFailed(c *gin.Context, id uint, err error) bool {
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Poll does not exist with id=%d", id))
+	} else if isInvalid(err) {
+		invalidBody(c, err)
+	} else if errors.Is(err, errInvalidPoll) {
+		badRequest(c, err.Error())
+	} else if errors.Is(err, errInvalidTransition) || errors.Is(err, errPollChanged) {
+		conflict(c, err.Error())
+	} else if err != nil {
+		log.Printf("Error updating poll %d: %v", id, err)
+		internalError(c, "Failed to update poll in cache")
+	}
+	return err != nil
+}
+
+// checkVotesKept says why votes cast in poll would no longer count in next,
+// or returns nil if they would
+func checkVotesKept(poll schema.Poll, next schema.Poll) error {
+	if next.PollType != poll.PollType {
+		return fmt.Errorf("%w: its PollType cannot change", errPollHasVotes)
+	}
+	for _, option := range poll.PollOptions {
+		if !hasOption(next, option.PollOptionID) {
+			return fmt.Errorf("%w: option %d cannot be removed", errPollHasVotes, option.PollOptionID)
+		}
+	}
+	return nil
+}
+
+// holdClosed closes the poll if it accepts votes, or may once it opens,
+// returning the status to put back once it is released, or "" if it was
+// not changed
+func (p *PollAPI) holdClosed(c *gin.Context, id uint) (schema.PollStatus, error) {
+	var heldFrom schema.PollStatus
+	_, err := p.polls.Update(c, id, func(poll *schema.Poll) error {
+		heldFrom = ""
+		if poll.PollStatus == schema.PollStatusOpen || poll.PollStatus == schema.PollStatusDraft {
+			heldFrom = poll.PollStatus
+			poll.PollStatus = schema.PollStatusClosed
+		}
+		return nil
+	})
+	return heldFrom, err
+}
+
+// releaseHold puts back the status holdClosed changed, unless the poll
+// moved on since. If that fails it answers with 500, as the poll is left
+// closed, and returns false.
+func (p *PollAPI) releaseHold(c *gin.Context, id uint, heldFrom schema.PollStatus) bool {
+	if heldFrom == "" {
+		return true
+	}
+	_, err := p.polls.Update(c, id, func(poll *schema.Poll) error {
+		if poll.PollStatus == schema.PollStatusClosed {
+			poll.PollStatus = heldFrom
+		}
+		return nil
+	})
+	if err != nil && err != store.ErrNotFound {
+		log.Printf("Failed to reopen poll %d: %v", id, err)
+		internalError(c, fmt.Sprintf("Poll %d was closed to count its votes and could not be reopened", id))
+		return false
+	}
+	return true
+}
+
+func hasOption(poll schema.Poll, optionID uint) bool {
+	for _, option := range poll.PollOptions {
+		if option.PollOptionID == optionID {
+			return true
+		}
+	}
+	return false
+}
+
+// DeletePoll deletes the poll in the :id param. A poll with votes is only
+// deleted with ?cascade=true, which deletes its votes in votes-api too, or
+// archived instead with ?archive=true, which keeps them.
+func (p *PollAPI) DeletePoll(c *gin.Context) {
+	id, ok := pollIDParam(c)
+	if !ok {
+		return
+	}
+	cascade, archive := c.Query("cascade") == "true", c.Query("archive") == "true"
+	if cascade && archive {
+		badRequest(c, "Pass either cascade or archive, not both")
 		return
 	}
 
-	// Generate a unique key for the poll in the cache, e.g., using a UUID
+	if archive {
+		// Archiving is how a poll ends for good, so it may skip the rest of
+		// the lifecycle
+		pollItem, err := p.polls.Update(c, id, func(poll *schema.Poll) error {
+			poll.PollStatus = schema.PollStatusArchived
+			return nil
+		})
+		if err == store.ErrNotFound {
+			notFound(c, fmt.Sprintf("Poll does not exist with id=%d", id))
+			return
+		} else if err != nil {
+			internalError(c, "Failed to update poll in cache")
+			return
+		}
+		c.JSON(http.StatusOK, pollItem)
+		return
+	}
 
-	pollKey := fmt.Sprintf("poll-%d", newPoll.PollID) // Adjust the key generation based on your needs
+	// Close the poll before counting its votes. votes-api reads the poll
+	// again once a vote is pending, so a vote cast from here on is either
+	// counted below or refused. The poll is reopened if it is not deleted.
+	heldFrom, err := p.holdClosed(c, id)
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Poll does not exist with id=%d", id))
+		return
+	} else if err != nil {
+		internalError(c, "Failed to update poll in cache")
+		return
+	}
 
-	// Set the key-value pair in the cache
-	err = p.client.Set(p.context, pollKey, pollJSON, 0).Err() // 0 means no expiration
+	hasVotes, err := p.hasVotes(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store poll in cache"})
+		if !p.releaseHold(c, id, heldFrom) {
+			return
+		}
+		log.Printf("Error checking the votes of poll %d: %v", id, err)
+		dependencyError(c, err, "Could not check the poll's votes")
+		return
+	}
+	if hasVotes && !cascade {
+		if !p.releaseHold(c, id, heldFrom) {
+			return
+		}
+		msg := fmt.Sprintf("Poll %d has votes: pass cascade=true to delete them too, or archive=true to archive the poll instead", id)
+		conflict(c, msg)
+		return
+	}
+
+	if hasVotes {
+		// If deleting the votes fails the poll stays closed and the request
+		// can be repeated
+		if err := p.deletePollVotes(c.Request.Context(), id); err != nil {
+			log.Printf("Error deleting the votes of poll %d: %v", id, err)
+			dependencyError(c, err, "Failed to delete the poll's votes")
+			return
+		}
+	}
+
+	err = p.polls.Delete(c, id)
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Poll does not exist with id=%d", id))
+		return
+	} else if err != nil {
+		internalError(c, "Failed to delete poll from cache")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Poll deleted successfully"})
+}
+
+func (p *PollAPI) OpenPoll(c *gin.Context) {
+	p.transitionPoll(c, schema.PollStatusOpen)
+}
+
+func (p *PollAPI) ClosePoll(c *gin.Context) {
+	p.transitionPoll(c, schema.PollStatusClosed)
+}
+
+func (p *PollAPI) ArchivePoll(c *gin.Context) {
+	p.transitionPoll(c, schema.PollStatusArchived)
+}
+
+// transitionPoll moves the poll in the :id param to the next state if the
+// lifecycle allows it
+func (p *PollAPI) transitionPoll(c *gin.Context, next schema.PollStatus) {
+	id, ok := pollIDParam(c)
+	if !ok {
+		return
+	}
+
+	pollItem, err := p.polls.Update(c, id, func(poll *schema.Poll) error {
+		if !poll.PollStatus.CanTransitionTo(next) {
+			return fmt.Errorf("%w: cannot move poll from %s to %s", errInvalidTransition, poll.PollStatus, next)
+		}
+		poll.PollStatus = next
+
+		// A manual transition overrides the schedule so the poll's window
+		// matches its state
+		now := time.Now().UTC()
+		if next == schema.PollStatusOpen && poll.OpensAt != nil && poll.OpensAt.After(now) {
+			poll.OpensAt = &now
+		}
+		if next == schema.PollStatusClosed && poll.ClosesAt != nil && poll.ClosesAt.After(now) {
+			poll.ClosesAt = &now
+		}
+		return nil
+	})
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Poll does not exist with id=%d", id))
+		return
+	} else if errors.Is(err, errInvalidTransition) {
+		conflict(c, err.Error())
+		return
+	} else if err != nil {
+		internalError(c, "Failed to update poll in cache")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Poll added to cache successfully"})
+	c.JSON(http.StatusOK, pollItem)
 }
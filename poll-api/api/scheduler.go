@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"poll-api/schema"
+	"poll-api/store"
+)
+
+const schedulerLockKey = "scheduler-lock-poll"
+
+var errNothingDue = errors.New("no scheduled transition due")
+
+// RunScheduler opens and closes polls as their scheduled times pass. Every
+// replica runs it, but a short-lived lock lets only one of them do the work
+// on each tick, and each transition is applied with an atomic update so a
+// concurrent manual change is never overwritten.
+func (p *PollAPI) RunScheduler(ctx context.Context, interval time.Duration) {
+	owner, _ := os.Hostname()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			acquired, err := p.polls.TryLock(ctx, schedulerLockKey, owner, interval)
+			if err != nil {
+				log.Println("Scheduler: error acquiring lock: " + err.Error())
+				continue
+			}
+			if !acquired {
+				continue
+			}
+
+			p.runDueTransitions(ctx, time.Now().UTC())
+		}
+	}
+}
+
+func (p *PollAPI) runDueTransitions(ctx context.Context, now time.Time) {
+	ids, err := p.polls.DueScheduled(ctx, now)
+	if err != nil {
+		log.Println("Scheduler: error reading schedule: " + err.Error())
+		return
+	}
+
+	for _, id := range ids {
+		pollItem, err := p.polls.Update(ctx, id, func(poll *schema.Poll) error {
+			next, ok := poll.DueStatus(now)
+			if !ok {
+				return errNothingDue
+			}
+			poll.PollStatus = next
+			return nil
+		})
+		if err == nil {
+			log.Printf("Scheduler: poll %d is now %s", id, pollItem.PollStatus)
+		} else if err != errNothingDue && err != store.ErrNotFound {
+			// Leave the poll scheduled so the next tick retries it
+			log.Printf("Scheduler: error updating poll %d: %v", id, err)
+			continue
+		}
+
+		if err := p.polls.ClearScheduled(ctx, id, now); err != nil {
+			log.Printf("Scheduler: error clearing schedule for poll %d: %v", id, err)
+		}
+	}
+}
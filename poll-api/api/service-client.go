@@ -0,0 +1,182 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Calls to the other services go through a serviceClient. Each attempt has
+// a timeout, attempts that fail on the network or with a 5xx are retried a
+// few times with backoff, and after repeated failures a circuit breaker
+// fails calls straight away for a while instead of piling more requests
+// onto a service that is down.
+
+var (
+	// errServiceUnavailable means the service could not be reached, or its
+	// circuit is open
+	errServiceUnavailable = errors.New("service unavailable")
+	// errServiceFailed means the service answered, but with an error or a
+	// response that makes no sense
+	errServiceFailed = errors.New("service failed")
+)
+
+const (
+	clientTimeout    = 5 * time.Second        // Per attempt
+	clientAttempts   = 3                      // Including the first one
+	clientBackoff    = 100 * time.Millisecond // Doubled after every failed attempt
+	breakerThreshold = 5                      // Failed calls in a row that open the circuit
+	breakerCooldown  = 30 * time.Second       // How long the circuit stays open
+)
+
+// serviceClient calls one service. Only idempotent requests go through it,
+// as any of them may be sent more than once.
+type serviceClient struct {
+	name    string // e.g. "voter-api", for errors and logs
+	baseURL string
+	http    *http.Client
+
+	mu        sync.Mutex
+	failures  int       // Failed calls in a row
+	openUntil time.Time // While in the future, calls fail without trying
+}
+
+func newServiceClient(name string, baseURL string) *serviceClient {
+	return &serviceClient{
+		name:    name,
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: clientTimeout},
+	}
+}
+
+// serviceResponse is a response with its body read, so callers never have
+// to close it
+type serviceResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// decode reads the JSON body into v
+func (r serviceResponse) decode(v interface{}) error {
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		return fmt.Errorf("%w: unreadable response: %v", errServiceFailed, err)
+	}
+	return nil
+}
+
+// unexpected is the error for a status the caller has no use for
+func (s *serviceClient) unexpected(resp serviceResponse, what string) error {
+	return fmt.Errorf("%w: %s returned %d %s", errServiceFailed, s.name, resp.StatusCode, what)
+}
+
+// do sends the request and returns any response below 500 for the caller
+// to map. body may be nil.
+func (s *serviceClient) do(ctx context.Context, method string, path string, body []byte) (serviceResponse, error) {
+	if !s.allow() {
+		return serviceResponse{}, fmt.Errorf("%w: %s is failing, not calling it for now", errServiceUnavailable, s.name)
+	}
+
+	var err error
+	backoff := clientBackoff
+	for attempt := 1; ; attempt++ {
+		var resp serviceResponse
+		resp, err = s.attempt(ctx, method, path, body)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			s.record(true)
+			return resp, nil
+		} else if err == nil {
+			err = s.unexpected(resp, method+" "+path)
+		} else {
+			err = fmt.Errorf("%w: %s: %v", errServiceUnavailable, s.name, err)
+		}
+
+		if attempt == clientAttempts {
+			break
+		}
+		jitter := time.Duration(rand.Int63n(int64(backoff / 2)))
+		select {
+		case <-time.After(backoff + jitter):
+		case <-ctx.Done():
+			return serviceResponse{}, err
+		}
+		backoff *= 2
+	}
+	// A caller that gave up says nothing about the service
+	if ctx.Err() == nil {
+		s.record(false)
+	}
+	return serviceResponse{}, err
+}
+
+func (s *serviceClient) attempt(ctx context.Context, method string, path string, body []byte) (serviceResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, reader)
+	if err != nil {
+		return serviceResponse{}, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.http.Do(request)
+	if err != nil {
+		return serviceResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return serviceResponse{}, err
+	}
+	return serviceResponse{StatusCode: resp.StatusCode, Header: resp.Header, Body: respBody}, nil
+}
+
+// allow reports whether a call may go out. Once the circuit has been open
+// for breakerCooldown a single trial call is let through; its outcome
+// closes the circuit or keeps it open.
+func (s *serviceClient) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures < breakerThreshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(s.openUntil) {
+		return false
+	}
+	s.openUntil = now.Add(breakerCooldown)
+	return true
+}
+
+func (s *serviceClient) record(ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ok {
+		if s.failures >= breakerThreshold {
+			log.Printf("%s is answering again", s.name)
+		}
+		s.failures = 0
+		return
+	}
+	s.failures++
+	if s.failures >= breakerThreshold {
+		if s.failures == breakerThreshold {
+			log.Printf("%s failed %d calls in a row, not calling it for %s", s.name, s.failures, breakerCooldown)
+		}
+		s.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+
+	"poll-api/schema"
+
+	"github.com/gin-gonic/gin"
+)
+
+const specTitle = "Poll API"
+
+// RegisterRoutes adds the API's routes to r. Each one needs an entry in
+// operations; spec_test.go checks that.
+func (p *PollAPI) RegisterRoutes(r gin.IRouter) {
+	r.GET("/polls", p.GetAllPolls)
+	r.POST("/polls", p.PostPoll)
+	r.GET("/polls/:id", p.GetPollByID)
+	r.PUT("/polls/:id", p.PutPoll)
+	r.PATCH("/polls/:id", p.PatchPoll)
+	r.DELETE("/polls/:id", p.DeletePoll)
+	r.POST("/polls/:id/open", p.OpenPoll)
+	r.POST("/polls/:id/close", p.ClosePoll)
+	r.POST("/polls/:id/archive", p.ArchivePoll)
+	r.GET("/openapi.json", OpenAPI)
+}
+
+// operations documents every route RegisterRoutes adds, for the OpenAPI
+// document
+var operations = []operation{
+	{
+		method:  http.MethodGet,
+		path:    "/polls",
+		summary: "List polls, page by page",
+		query: append(pageQuery("id, title"),
+			param{"status", "Only polls in this status"},
+			param{"title", "Only polls whose title contains this, ignoring case"}),
+		responses: append([]response{pageResponse([]schema.Poll{})}, errorResponses(http.StatusBadRequest, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodPost,
+		path:      "/polls",
+		summary:   "Create a draft poll; a zero PollID is picked by the API",
+		body:      schema.Poll{},
+		responses: append([]response{createdResponse(schema.Poll{}, "poll")}, errorResponses(http.StatusBadRequest, http.StatusConflict, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodGet,
+		path:      "/polls/:id",
+		summary:   "Get a poll",
+		responses: append([]response{okResponse(schema.Poll{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		method:  http.MethodPut,
+		path:    "/polls/:id",
+		summary: "Replace a poll; once it has votes its options and type cannot change",
+		body:    schema.Poll{},
+		responses: append([]response{okResponse(schema.Poll{})},
+			errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusInternalServerError)...),
+	},
+	{
+		method:  http.MethodPatch,
+		path:    "/polls/:id",
+		summary: "Change part of a poll with a JSON Merge Patch",
+		body:    mergePatchBody{},
+		responses: append([]response{okResponse(schema.Poll{})},
+			errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusInternalServerError)...),
+	},
+	{
+		method:  http.MethodDelete,
+		path:    "/polls/:id",
+		summary: "Delete a poll; one with votes needs cascade or archive",
+		query: []param{
+			{"cascade", "true deletes the poll's votes too"},
+			{"archive", "true archives the poll instead, keeping its votes; answers with the poll"},
+		},
+		responses: append([]response{okResponse(messageBody{})},
+			errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodPost,
+		path:      "/polls/:id/open",
+		summary:   "Open a draft poll for voting",
+		responses: append([]response{okResponse(schema.Poll{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodPost,
+		path:      "/polls/:id/close",
+		summary:   "Close an open poll",
+		responses: append([]response{okResponse(schema.Poll{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodPost,
+		path:      "/polls/:id/archive",
+		summary:   "Archive a draft or closed poll",
+		responses: append([]response{okResponse(schema.Poll{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodGet,
+		path:      "/openapi.json",
+		summary:   "This document",
+		responses: append([]response{okResponse(map[string]interface{}{})}, errorResponses(http.StatusInternalServerError)...),
+	},
+}
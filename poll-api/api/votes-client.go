@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// hasVotes asks votes-api whether any vote was cast in the poll, counting
+// the ones still being cast
+func (p *PollAPI) hasVotes(ctx context.Context, id uint) (bool, error) {
+	resp, err := p.votesAPI.do(ctx, http.MethodGet, fmt.Sprintf("/polls/%d/votes", id), nil)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, p.votesAPI.unexpected(resp, fmt.Sprintf("counting the votes of poll %d", id))
+	}
+	var count struct {
+		Votes   int64
+		Pending int
+	}
+	if err := resp.decode(&count); err != nil {
+		return false, err
+	}
+	return count.Votes > 0 || count.Pending > 0, nil
+}
+
+// deletePollVotes asks votes-api to delete every vote cast in the poll
+func (p *PollAPI) deletePollVotes(ctx context.Context, id uint) error {
+	resp, err := p.votesAPI.do(ctx, http.MethodDelete, fmt.Sprintf("/polls/%d/votes", id), nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return p.votesAPI.unexpected(resp, fmt.Sprintf("deleting the votes of poll %d", id))
+	}
+	return nil
+}
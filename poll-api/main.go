@@ -1,31 +1,39 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"poll-api/api"
+	"poll-api/store"
 	"strconv"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 var (
-	hostFlag string
-	portFlag uint
-	cacheURL string
+	hostFlag  string
+	portFlag  uint
+	cacheURL  string
+	storeFlag string
+
+	schedulerInterval time.Duration
 	// voterAPIURL string
-	// votesAPIURL string
+	votesAPIURL string
 )
 
 func processCmdLineFlags() {
 	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
 	flag.StringVar(&cacheURL, "c", "0.0.0.0:6379", "Default cache location")
+	flag.StringVar(&storeFlag, "store", "redis", "Storage backend: redis or memory")
 	// flag.StringVar(&voterAPIURL, "voterapi", "http://localhost:1080", "Default endpoint for voter API")
-	// flag.StringVar(&votesAPIURL, "votesapi", "http://localhost:3080", "Default endpoint for votes API")
+	flag.StringVar(&votesAPIURL, "votesapi", "http://localhost:3080", "Default endpoint for votes API")
 	flag.UintVar(&portFlag, "p", 2080, "Default Port")
+	flag.DurationVar(&schedulerInterval, "s", 10*time.Second, "How often the scheduler opens and closes polls")
 
 	flag.Parse()
 }
@@ -44,8 +52,9 @@ func setupParms() {
 
 	//now process any environment variables
 	cacheURL = envVarOrDefault("REDIS_URL", cacheURL)
+	storeFlag = envVarOrDefault("STORE_BACKEND", storeFlag)
 	// voterAPIURL = envVarOrDefault("ELECTION_VOTER_API_URL", voterAPIURL)
-	// votesAPIURL = envVarOrDefault("ELECTION_VOTES_API_URL", votesAPIURL)
+	votesAPIURL = envVarOrDefault("ELECTION_VOTES_API_URL", votesAPIURL)
 	hostFlag = envVarOrDefault("POLL_API_HOST", hostFlag)
 
 	pfNew, err := strconv.Atoi(envVarOrDefault("ELECTION_API_PORT", fmt.Sprintf("%d", portFlag)))
@@ -55,29 +64,72 @@ func setupParms() {
 		portFlag = uint(pfNew)
 	}
 
+	siNew, err := time.ParseDuration(envVarOrDefault("POLL_SCHEDULER_INTERVAL", schedulerInterval.String()))
+	if err == nil {
+		schedulerInterval = siNew
+	}
+
+}
+
+// newPollRepository picks the storage backend. The memory backend lets the
+// service run without Redis, but polls only live as long as the process.
+func newPollRepository() (store.PollRepository, error) {
+	switch storeFlag {
+	case "redis":
+		return store.NewRedisPollRepository("redis:6379")
+	case "memory":
+		return store.NewMemoryPollRepository(), nil
+	}
+	return nil, fmt.Errorf("unknown store backend %q (expected redis or memory)", storeFlag)
+}
+
+func runMigrate(args []string) {
+	polls, err := store.NewRedisPollRepository(cacheURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := store.RunMigrateCommand(context.Background(), polls.Migrator(), args); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func main() {
 	//this will allow the user to override key parameters and also setup defaults
 	setupParms()
 	log.Println("Init/cacheURL: " + cacheURL)
+	log.Println("Init/store: " + storeFlag)
 	// log.Println("Init/VOTERAPIURL: " + voterAPIURL)
-	// log.Println("Init/VOTESAPIURL: " + votesAPIURL)
+	log.Println("Init/VOTESAPIURL: " + votesAPIURL)
 	log.Println("Init/hostFlag: " + hostFlag)
 	log.Printf("Init/portFlag: %d", portFlag)
+	log.Printf("Init/schedulerInterval: %s", schedulerInterval)
+
+	// "poll-api migrate ..." migrates the polls stored in Redis and exits
+	if flag.Arg(0) == "migrate" {
+		runMigrate(flag.Args()[1:])
+		return
+	}
 
-	apiHandler, err := api.NewPollAPI("redis:6379")
+	polls, err := newPollRepository()
 
 	if err != nil {
 		panic(err)
 	}
 
+	apiHandler := api.NewPollAPI(polls, votesAPIURL)
+
+	go apiHandler.RunScheduler(context.Background(), schedulerInterval)
+
 	r := gin.Default()
 	r.Use(cors.Default())
 
-	r.GET("/polls", apiHandler.GetAllPolls)
-	r.POST("/polls", apiHandler.PostPoll)
-	r.GET("/polls/:id", apiHandler.GetPollByID)
+	apiHandler.RegisterRoutes(r)
+	r.NoRoute(api.NoRoute)
+
+	// spec_test.go keeps the OpenAPI document complete, this only warns
+	if err := api.CheckSpec(r.Routes()); err != nil {
+		log.Println("Warning: " + err.Error())
+	}
 
 	//For now we will just support gets
 	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
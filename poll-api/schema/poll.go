@@ -1,13 +1,137 @@
 package schema
 
-type pollOption struct {
-	PollOptionID   uint
-	PollOptionText string
+import "time"
+
+type PollStatus string
+
+const (
+	PollStatusDraft    PollStatus = "draft"
+	PollStatusOpen     PollStatus = "open"
+	PollStatusClosed   PollStatus = "closed"
+	PollStatusArchived PollStatus = "archived"
+)
+
+// pollTransitions lists the states a poll may move to from its current state
+var pollTransitions = map[PollStatus][]PollStatus{
+	PollStatusDraft:  {PollStatusOpen, PollStatusArchived},
+	PollStatusOpen:   {PollStatusClosed},
+	PollStatusClosed: {PollStatusArchived},
+}
+
+func (s PollStatus) IsValid() bool {
+	switch s {
+	case PollStatusDraft, PollStatusOpen, PollStatusClosed, PollStatusArchived:
+		return true
+	}
+	return false
+}
+
+func (s PollStatus) CanTransitionTo(next PollStatus) bool {
+	for _, allowed := range pollTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+type PollType string
+
+const (
+	PollTypeSingle   PollType = "single"   // One option per vote (the default)
+	PollTypeRanked   PollType = "ranked"   // Ordered preferences, decided by instant-runoff
+	PollTypeApproval PollType = "approval" // Any number of options, within optional limits
+	PollTypeMulti    PollType = "multi"    // Up to MaxSelections options
+	PollTypeScore    PollType = "score"    // Every option rated between MinScore and MaxScore
+)
+
+// Score polls without an explicit range are rated 0-5
+const DefaultMaxScore = 5
+
+func (t PollType) IsValid() bool {
+	switch t {
+	case PollTypeSingle, PollTypeRanked, PollTypeApproval, PollTypeMulti, PollTypeScore:
+		return true
+	}
+	return false
+}
+
+// The `binding` rules are checked on every poll sent to the API
+
+type PollOption struct {
+	PollOptionID   uint   `binding:"required"`
+	PollOptionText string `binding:"notblank"`
 }
 
 type Poll struct {
 	PollID       uint
-	PollTitle    string
+	PollTitle    string `binding:"notblank"`
 	PollQuestion string
-	PollOptions  []pollOption
+	PollOptions  []PollOption `binding:"required,min=1,unique=PollOptionID,dive"`
+	PollStatus   PollStatus   `binding:"omitempty,oneof=draft open closed archived"`
+	PollType     PollType     `binding:"omitempty,oneof=single ranked approval multi score"`
+	// Approval and multi-select polls only: how many options a vote may
+	// select. Zero means no lower bound beyond one / no upper bound.
+	MinSelections uint
+	MaxSelections uint
+	// Score polls only: the range each option is rated in
+	MinScore uint
+	MaxScore uint
+	OpensAt  *time.Time // Optional: the scheduler opens a draft poll at this time
+	ClosesAt *time.Time // Optional: the scheduler closes an open poll at this time
+	// How many seconds after it is cast a vote may still be changed or
+	// retracted while the poll is open. Zero makes votes final.
+	VoteChangeSeconds uint
+}
+
+// DueStatus returns the state the scheduler should move the poll to at the
+// given time, or false if no scheduled transition is due
+func (p *Poll) DueStatus(now time.Time) (PollStatus, bool) {
+	closed := p.ClosesAt != nil && !now.Before(*p.ClosesAt)
+	switch {
+	case p.PollStatus == PollStatusOpen && closed:
+		return PollStatusClosed, true
+	case p.PollStatus == PollStatusDraft && !closed && p.OpensAt != nil && !now.Before(*p.OpensAt):
+		return PollStatusOpen, true
+	}
+	return "", false
+}
+
+// CheckSelectionLimits reports a problem with MinSelections/MaxSelections,
+// or "" if they are consistent with the poll's type and options
+func (p *Poll) CheckSelectionLimits() string {
+	if p.PollType != PollTypeApproval && p.PollType != PollTypeMulti {
+		if p.MinSelections != 0 || p.MaxSelections != 0 {
+			return "MinSelections and MaxSelections only apply to approval and multi-select polls"
+		}
+		return ""
+	}
+	if p.PollType == PollTypeMulti && p.MaxSelections == 0 {
+		return "Multi-select polls need a MaxSelections"
+	}
+	if p.MaxSelections != 0 && p.MinSelections > p.MaxSelections {
+		return "MinSelections cannot be greater than MaxSelections"
+	}
+	if p.MinSelections > uint(len(p.PollOptions)) || p.MaxSelections > uint(len(p.PollOptions)) {
+		return "Selection limits cannot exceed the number of options"
+	}
+	return ""
+}
+
+// CheckScoreRange fills in the default range for score polls and reports a
+// problem with MinScore/MaxScore, or "" if they are consistent
+func (p *Poll) CheckScoreRange() string {
+	if p.PollType != PollTypeScore {
+		if p.MinScore != 0 || p.MaxScore != 0 {
+			return "MinScore and MaxScore only apply to score polls"
+		}
+		return ""
+	}
+	if p.MinScore == 0 && p.MaxScore == 0 {
+		p.MaxScore = DefaultMaxScore
+	}
+	if p.MaxScore <= p.MinScore {
+		return "MaxScore must be greater than MinScore"
+	}
+	return ""
 }
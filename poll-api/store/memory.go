@@ -0,0 +1,187 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"poll-api/schema"
+)
+
+// MemoryPollRepository keeps polls in process memory. Polls are stored
+// marshalled, like in Redis, so callers never share slices with the store.
+type MemoryPollRepository struct {
+	mu     sync.Mutex
+	polls  map[uint][]byte
+	opens  map[uint]time.Time
+	closes map[uint]time.Time
+	locks  map[string]time.Time
+	lastID uint
+}
+
+func NewMemoryPollRepository() *MemoryPollRepository {
+	return &MemoryPollRepository{
+		polls:  map[uint][]byte{},
+		opens:  map[uint]time.Time{},
+		closes: map[uint]time.Time{},
+		locks:  map[string]time.Time{},
+	}
+}
+
+func (m *MemoryPollRepository) Get(ctx context.Context, id uint) (schema.Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.polls[id]
+	if !ok {
+		return schema.Poll{}, ErrNotFound
+	}
+	return decodePoll(value)
+}
+
+func (m *MemoryPollRepository) List(ctx context.Context, filter PollFilter, page Page) ([]schema.Poll, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	polls := map[uint]schema.Poll{}
+	var items []sortItem
+	for id, value := range m.polls {
+		pollItem, err := decodePoll(value)
+		if err != nil {
+			return nil, "", err
+		}
+		if !filter.matches(pollItem) {
+			continue
+		}
+		polls[id] = pollItem
+		item := sortItem{id: id}
+		if page.Sort == SortByTitle {
+			item.key = titleKey(pollItem)
+		}
+		items = append(items, item)
+	}
+
+	ids, next, err := pageItems(items, page)
+	if err != nil {
+		return nil, "", err
+	}
+	var pollList []schema.Poll
+	for _, id := range ids {
+		pollList = append(pollList, polls[id])
+	}
+	return pollList, next, nil
+}
+
+func (m *MemoryPollRepository) NextID(ctx context.Context) (uint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID++
+	return m.lastID, nil
+}
+
+func (m *MemoryPollRepository) Create(ctx context.Context, poll schema.Poll) error {
+	pollJSON, err := json.Marshal(poll)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.polls[poll.PollID]; ok {
+		return ErrExists
+	}
+	m.polls[poll.PollID] = pollJSON
+	m.schedule(poll)
+	return nil
+}
+
+func (m *MemoryPollRepository) Delete(ctx context.Context, id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.polls[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.polls, id)
+	delete(m.opens, id)
+	delete(m.closes, id)
+	return nil
+}
+
+func (m *MemoryPollRepository) Update(ctx context.Context, id uint, fn func(*schema.Poll) error) (schema.Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.polls[id]
+	if !ok {
+		return schema.Poll{}, ErrNotFound
+	}
+	pollItem, err := decodePoll(value)
+	if err != nil {
+		return pollItem, err
+	}
+	if err := fn(&pollItem); err != nil {
+		return pollItem, err
+	}
+
+	pollJSON, err := json.Marshal(pollItem)
+	if err != nil {
+		return pollItem, err
+	}
+	m.polls[id] = pollJSON
+	m.schedule(pollItem)
+	return pollItem, nil
+}
+
+func (m *MemoryPollRepository) schedule(poll schema.Poll) {
+	if poll.OpensAt != nil {
+		m.opens[poll.PollID] = *poll.OpensAt
+	}
+	if poll.ClosesAt != nil {
+		m.closes[poll.PollID] = *poll.ClosesAt
+	}
+}
+
+func (m *MemoryPollRepository) DueScheduled(ctx context.Context, now time.Time) ([]uint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := map[uint]bool{}
+	var ids []uint
+	for _, queue := range []map[uint]time.Time{m.opens, m.closes} {
+		for id, at := range queue {
+			if !at.After(now) && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (m *MemoryPollRepository) ClearScheduled(ctx context.Context, id uint, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, queue := range []map[uint]time.Time{m.opens, m.closes} {
+		if at, ok := queue[id]; ok && !at.After(now) {
+			delete(queue, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryPollRepository) TryLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if expires, ok := m.locks[name]; ok && now.Before(expires) {
+		return false, nil
+	}
+	m.locks[name] = now.Add(ttl)
+	return true, nil
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// pollMigrations are the changes made to stored polls, oldest first. Run
+// them with "poll-api migrate up".
+func pollMigrations(docs docStore) []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "store the status and type of polls saved before polls had them",
+			Up:          docs.fillPollDefaults,
+			// decodePoll fills in the same defaults, so there is nothing to undo
+		},
+		{
+			Version:     2,
+			Description: "store polls as RedisJSON documents",
+			Up:          docs.toDocuments,
+			Down:        docs.fromDocuments,
+		},
+	}
+}
+
+// Migrator runs the poll migrations over this repository's Redis
+func (r *RedisPollRepository) Migrator() *Migrator {
+	return newMigrator(r.client, "polls", "poll-*", pollMigrations(r.docs))
+}
+
+// fillPollDefaults stores what decodePoll reports for polls without a
+// PollStatus or PollType. The status index is brought in step as well.
+func (docs docStore) fillPollDefaults(ctx context.Context, tx *redis.Tx, key string) error {
+	value, err := docs.get(ctx, tx, key)
+	if err == redis.Nil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var stored struct {
+		PollStatus string
+		PollType   string
+	}
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return err
+	}
+	if stored.PollStatus != "" && stored.PollType != "" {
+		return nil
+	}
+
+	pollItem, err := decodePoll(value)
+	if err != nil {
+		return err
+	}
+	pollJSON, err := json.Marshal(pollItem)
+	if err != nil {
+		return err
+	}
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		docs.set(ctx, pipe, key, pollJSON)
+		indexPoll(ctx, pipe, "", pollItem)
+		return nil
+	})
+	return err
+}
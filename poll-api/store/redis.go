@@ -0,0 +1,404 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"poll-api/schema"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Scheduled transitions are kept in sorted sets scored by unix time so the
+// scheduler only has to look at polls that are due. The key names
+// deliberately do not start with "poll-" so they never show up in the poll
+// listing.
+const (
+	scheduleOpenKey  = "schedule-poll-open"
+	scheduleCloseKey = "schedule-poll-close"
+	nextPollIDKey    = "next-id-polls" // Counter NextID allocates from
+)
+
+// Listing indexes. pollIndexKey is a sorted set of every poll ID, scored by
+// the ID, which lets polls be listed page by page without a KEYS scan. The
+// title index maps each poll ID to its titleKey and a set per status holds
+// the IDs of the polls in that status.
+const (
+	pollIndexKey      = "index-polls"
+	pollTitleIndexKey = "index-polls-title"
+)
+
+func pollStatusIndexKey(status schema.PollStatus) string {
+	return "index-polls-status-" + string(status)
+}
+
+// clearScheduledScript removes a poll from each schedule it is due in
+var clearScheduledScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	local score = redis.call("ZSCORE", key, ARGV[1])
+	if score and tonumber(score) <= tonumber(ARGV[2]) then
+		redis.call("ZREM", key, ARGV[1])
+	end
+end
+return 0
+`)
+
+type RedisPollRepository struct {
+	client *redis.Client
+	docs   docStore
+}
+
+func NewRedisPollRepository(location string) (*RedisPollRepository, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr: location,
+	})
+
+	ctx := context.Background()
+
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		log.Println("Error connecting to redis" + err.Error())
+		return nil, err
+	}
+
+	r := &RedisPollRepository{
+		client: client,
+		docs:   newDocStore(ctx, client),
+	}
+	if err := r.buildIndex(ctx); err != nil {
+		log.Println("Error building poll index: " + err.Error())
+		return nil, err
+	}
+	return r, nil
+}
+
+// buildIndex indexes polls stored before the indexes existed. It only runs
+// while an index is missing and uses SCAN, so it never blocks Redis.
+func (r *RedisPollRepository) buildIndex(ctx context.Context) error {
+	indexed, err := r.client.Exists(ctx, pollIndexKey, pollTitleIndexKey).Result()
+	if err != nil || indexed == 2 {
+		return err
+	}
+
+	iter := r.client.Scan(ctx, 0, "poll-*", 1000).Iterator()
+	for iter.Next(ctx) {
+		value, err := r.docs.get(ctx, r.client, iter.Val())
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return err
+		}
+		pollItem, err := decodePoll(value)
+		if err != nil {
+			log.Printf("Not indexing %s: %v", iter.Val(), err)
+			continue
+		}
+
+		_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			indexPoll(ctx, pipe, "", pollItem)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func pollKey(id uint) string {
+	return fmt.Sprintf("poll-%d", id)
+}
+
+func (r *RedisPollRepository) Get(ctx context.Context, id uint) (schema.Poll, error) {
+	value, err := r.docs.get(ctx, r.client, pollKey(id))
+	if err == redis.Nil {
+		return schema.Poll{}, ErrNotFound
+	} else if err != nil {
+		return schema.Poll{}, err
+	}
+	return decodePoll(value)
+}
+
+func (r *RedisPollRepository) List(ctx context.Context, filter PollFilter, page Page) ([]schema.Poll, string, error) {
+	var ids []uint
+	var next string
+	var err error
+	if filter == (PollFilter{}) && page.byID() {
+		ids, next, err = r.pageByID(ctx, page)
+	} else {
+		ids, next, err = r.query(ctx, filter, page)
+	}
+	if err != nil || len(ids) == 0 {
+		return nil, "", err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = pollKey(id)
+	}
+	values, err := r.docs.mget(ctx, keys...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var pollList []schema.Poll
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			// Indexed but gone, e.g. deleted while we were reading
+			continue
+		}
+		pollItem, err := decodePoll([]byte(str))
+		if err != nil {
+			return nil, "", fmt.Errorf("could not read %s: %w", keys[i], err)
+		}
+		pollList = append(pollList, pollItem)
+	}
+	return pollList, next, nil
+}
+
+// pageByID reads an unfiltered page in ID order straight from the ID index
+func (r *RedisPollRepository) pageByID(ctx context.Context, page Page) ([]uint, string, error) {
+	min, max, err := page.scoreRange()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Ask the index for one poll more than the page to know if there is a
+	// next page
+	by := &redis.ZRangeBy{Min: min, Max: max, Count: int64(page.Limit) + 1}
+	var members []string
+	if page.Desc {
+		members, err = r.client.ZRevRangeByScore(ctx, pollIndexKey, by).Result()
+	} else {
+		members, err = r.client.ZRangeByScore(ctx, pollIndexKey, by).Result()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids, next := limitItems(idItems(parseIDs(members)), page)
+	return ids, next, nil
+}
+
+// query works out a filtered or sorted page from the secondary indexes
+// alone, so only the polls on the page are read
+func (r *RedisPollRepository) query(ctx context.Context, filter PollFilter, page Page) ([]uint, string, error) {
+	var statusIDs, titleIDs []uint
+	if filter.Status != "" {
+		members, err := r.client.SMembers(ctx, pollStatusIndexKey(filter.Status)).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		statusIDs = parseIDs(members)
+	}
+
+	var titles map[string]string
+	if filter.Title != "" || page.Sort == SortByTitle {
+		var err error
+		titles, err = r.client.HGetAll(ctx, pollTitleIndexKey).Result()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if filter.Title != "" {
+		var matching []string
+		for id, title := range titles {
+			if strings.Contains(title, strings.ToLower(filter.Title)) {
+				matching = append(matching, id)
+			}
+		}
+		titleIDs = parseIDs(matching)
+	}
+
+	ids := intersect(statusIDs, titleIDs)
+	if ids == nil {
+		members, err := r.client.ZRange(ctx, pollIndexKey, 0, -1).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		ids = parseIDs(members)
+	}
+
+	items := idItems(ids)
+	if page.Sort == SortByTitle {
+		for i := range items {
+			items[i].key = titles[strconv.FormatUint(uint64(items[i].id), 10)]
+		}
+	}
+	return pageItems(items, page)
+}
+
+func (r *RedisPollRepository) NextID(ctx context.Context) (uint, error) {
+	id, err := r.client.Incr(ctx, nextPollIDKey).Result()
+	return uint(id), err
+}
+
+func (r *RedisPollRepository) Create(ctx context.Context, poll schema.Poll) error {
+	pollJSON, err := json.Marshal(poll)
+	if err != nil {
+		return err
+	}
+	key := pollKey(poll.PollID)
+
+	// WATCH the key so the uniqueness check, the write and queueing any
+	// scheduled transitions are a single step
+	txf := func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return ErrExists
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.docs.set(ctx, pipe, key, pollJSON)
+			indexPoll(ctx, pipe, "", poll)
+			schedule(ctx, pipe, poll)
+			return nil
+		})
+		return err
+	}
+	return r.watch(ctx, key, txf)
+}
+
+func (r *RedisPollRepository) Update(ctx context.Context, id uint, fn func(*schema.Poll) error) (schema.Poll, error) {
+	var pollItem schema.Poll
+	key := pollKey(id)
+
+	// WATCH the poll so the write fails, and is retried, if another writer
+	// changes the poll in the meantime
+	txf := func(tx *redis.Tx) error {
+		value, err := r.docs.get(ctx, tx, key)
+		if err == redis.Nil {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		pollItem, err = decodePoll(value)
+		if err != nil {
+			return err
+		}
+		oldStatus := pollItem.PollStatus
+		if err := fn(&pollItem); err != nil {
+			return err
+		}
+
+		pollJSON, err := json.Marshal(pollItem)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.docs.set(ctx, pipe, key, pollJSON)
+			indexPoll(ctx, pipe, oldStatus, pollItem)
+			schedule(ctx, pipe, pollItem)
+			return nil
+		})
+		return err
+	}
+	return pollItem, r.watch(ctx, key, txf)
+}
+
+func (r *RedisPollRepository) Delete(ctx context.Context, id uint) error {
+	key := pollKey(id)
+
+	txf := func(tx *redis.Tx) error {
+		value, err := r.docs.get(ctx, tx, key)
+		if err == redis.Nil {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		pollItem, err := decodePoll(value)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.ZRem(ctx, pollIndexKey, id)
+			pipe.SRem(ctx, pollStatusIndexKey(pollItem.PollStatus), id)
+			pipe.HDel(ctx, pollTitleIndexKey, strconv.FormatUint(uint64(id), 10))
+			pipe.ZRem(ctx, scheduleOpenKey, key)
+			pipe.ZRem(ctx, scheduleCloseKey, key)
+			return nil
+		})
+		return err
+	}
+	return r.watch(ctx, key, txf)
+}
+
+// watch runs txf with the poll's key WATCHed, retrying it while another
+// writer changes the poll in the meantime
+func (r *RedisPollRepository) watch(ctx context.Context, key string, txf func(*redis.Tx) error) error {
+	for i := 0; i < maxTxRetries; i++ {
+		err := r.client.Watch(ctx, txf, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("poll %s changed too often to update", key)
+}
+
+// indexPoll records the poll in the listing indexes, taking it out of the
+// set of its old status if that changed
+func indexPoll(ctx context.Context, pipe redis.Pipeliner, oldStatus schema.PollStatus, poll schema.Poll) {
+	if oldStatus != "" && oldStatus != poll.PollStatus {
+		pipe.SRem(ctx, pollStatusIndexKey(oldStatus), poll.PollID)
+	}
+	pipe.ZAdd(ctx, pollIndexKey, &redis.Z{Score: float64(poll.PollID), Member: poll.PollID})
+	pipe.SAdd(ctx, pollStatusIndexKey(poll.PollStatus), poll.PollID)
+	pipe.HSet(ctx, pollTitleIndexKey, strconv.FormatUint(uint64(poll.PollID), 10), titleKey(poll))
+}
+
+// schedule queues the poll's OpensAt/ClosesAt times for the scheduler
+func schedule(ctx context.Context, pipe redis.Pipeliner, poll schema.Poll) {
+	if poll.OpensAt != nil {
+		pipe.ZAdd(ctx, scheduleOpenKey, &redis.Z{Score: float64(poll.OpensAt.Unix()), Member: pollKey(poll.PollID)})
+	}
+	if poll.ClosesAt != nil {
+		pipe.ZAdd(ctx, scheduleCloseKey, &redis.Z{Score: float64(poll.ClosesAt.Unix()), Member: pollKey(poll.PollID)})
+	}
+}
+
+func (r *RedisPollRepository) DueScheduled(ctx context.Context, now time.Time) ([]uint, error) {
+	seen := map[uint]bool{}
+	var ids []uint
+
+	for _, scheduleKey := range []string{scheduleOpenKey, scheduleCloseKey} {
+		keys, err := r.client.ZRangeByScore(ctx, scheduleKey, &redis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(now.Unix(), 10),
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			id, err := strconv.ParseUint(strings.TrimPrefix(key, "poll-"), 10, 64)
+			if err != nil || seen[uint(id)] {
+				continue
+			}
+			seen[uint(id)] = true
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids, nil
+}
+
+func (r *RedisPollRepository) ClearScheduled(ctx context.Context, id uint, now time.Time) error {
+	keys := []string{scheduleOpenKey, scheduleCloseKey}
+	return clearScheduledScript.Run(ctx, r.client, keys, pollKey(id), now.Unix()).Err()
+}
+
+func (r *RedisPollRepository) TryLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, name, owner, ttl).Result()
+}
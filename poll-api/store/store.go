@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"poll-api/schema"
+)
+
+const (
+	RedisNilError        = "redis: nil"
+	RedisDefaultLocation = "0.0.0.0:6379"
+
+	maxTxRetries = 10
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+)
+
+// SortByTitle orders the poll listing by PollTitle, ignoring case
+const SortByTitle = "title"
+
+// PollFilter narrows the poll listing. Zero fields match every poll.
+type PollFilter struct {
+	Status schema.PollStatus
+	Title  string // Case-insensitive substring of PollTitle
+}
+
+func (f PollFilter) matches(poll schema.Poll) bool {
+	if f.Status != "" && poll.PollStatus != f.Status {
+		return false
+	}
+	return strings.Contains(titleKey(poll), strings.ToLower(f.Title))
+}
+
+// titleKey is what polls are searched and sorted by title on
+func titleKey(poll schema.Poll) string {
+	return strings.ToLower(poll.PollTitle)
+}
+
+// PollRepository stores polls along with the queue of scheduled transitions.
+// There is a Redis implementation for production and an in-memory one for
+// running the service without Redis.
+type PollRepository interface {
+	Get(ctx context.Context, id uint) (schema.Poll, error)
+	// List returns a page of the polls matching the filter and the cursor
+	// for the next page ("" after the last page)
+	List(ctx context.Context, filter PollFilter, page Page) ([]schema.Poll, string, error)
+	// NextID allocates an ID for a new poll. IDs are never handed out
+	// twice, but a client may have picked one already, so Create can still
+	// return ErrExists.
+	NextID(ctx context.Context) (uint, error)
+	// Create stores a new poll, or returns ErrExists if the ID is taken
+	Create(ctx context.Context, poll schema.Poll) error
+	Delete(ctx context.Context, id uint) error
+
+	// Update applies fn to the stored poll atomically, so concurrent
+	// updates (from handlers or the scheduler on another replica) are never
+	// lost. An error from fn aborts the update and is returned as is.
+	Update(ctx context.Context, id uint, fn func(*schema.Poll) error) (schema.Poll, error)
+
+	// DueScheduled lists the polls with an OpensAt or ClosesAt at or before
+	// now, and ClearScheduled takes a poll off that list once handled
+	DueScheduled(ctx context.Context, now time.Time) ([]uint, error)
+	ClearScheduled(ctx context.Context, id uint, now time.Time) error
+
+	// TryLock takes the named lock for ttl if nobody else holds it
+	TryLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error)
+}
+
+// decodePoll unmarshals a stored poll. Polls stored before lifecycle states
+// existed have no status and were always accepting votes, so they are
+// reported as open. Likewise polls without a type are single-choice.
+func decodePoll(value []byte) (schema.Poll, error) {
+	var pollItem schema.Poll
+	if err := json.Unmarshal(value, &pollItem); err != nil {
+		return pollItem, err
+	}
+	if pollItem.PollStatus == "" {
+		pollItem.PollStatus = schema.PollStatusOpen
+	}
+	if pollItem.PollType == "" {
+		pollItem.PollType = schema.PollTypeSingle
+	}
+	return pollItem, nil
+}
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The OpenAPI 3 document served at /openapi.json is built from the
+// operations listed in spec.go. Request and response schemas are generated
+// from the Go types the handlers bind and return, so they follow the schema
+// package as it changes. CheckSpec lists the registered routes that are
+// missing from the list, which the service warns about when it starts.
+
+// operation documents one route
+type operation struct {
+	method    string
+	path      string // As registered with gin, e.g. "/polls/:id"
+	summary   string
+	query     []param
+	body      interface{} // A value of the request body's type, nil if there is none
+	responses []response
+}
+
+type param struct {
+	name        string
+	description string
+}
+
+type response struct {
+	status  int
+	body    interface{} // A value of the body's type, nil if there is none
+	headers map[string]string
+}
+
+// messageBody stands for the {"message": ...} body the handlers answer
+// with. mergePatchBody stands for a JSON Merge Patch of the resource.
+type (
+	messageBody    struct{}
+	mergePatchBody struct{}
+)
+
+func okResponse(body interface{}) response {
+	return response{status: http.StatusOK, body: body}
+}
+
+func createdResponse(body interface{}, location string) response {
+	return response{status: http.StatusCreated, body: body, headers: map[string]string{
+		"Location": "Link to the new " + location,
+	}}
+}
+
+// pageResponse is the answer of a listing: a page of items, with the
+// cursor of the next page in a header
+func pageResponse(items interface{}) response {
+	return response{status: http.StatusOK, body: items, headers: map[string]string{
+		"X-Next-Cursor": "Cursor of the next page, absent on the last page",
+	}}
+}
+
+func errorResponses(statuses ...int) []response {
+	var responses []response
+	for _, status := range statuses {
+		responses = append(responses, response{status: status, body: Problem{}})
+	}
+	return responses
+}
+
+// pageQuery lists the query params of a listing; sorts names the orders
+// it supports
+func pageQuery(sorts string) []param {
+	return []param{
+		{"limit", "Page size"},
+		{"cursor", "Cursor of the page, from the X-Next-Cursor header of the previous one"},
+		{"sort", "Order: " + sorts + ", prefixed with - for descending order"},
+	}
+}
+
+var (
+	specOnce sync.Once
+	spec     map[string]interface{}
+)
+
+// OpenAPI serves the OpenAPI document
+func OpenAPI(c *gin.Context) {
+	specOnce.Do(func() {
+		spec = buildSpec()
+	})
+	c.JSON(http.StatusOK, spec)
+}
+
+// CheckSpec returns an error naming the registered routes the OpenAPI
+// document does not describe
+func CheckSpec(routes gin.RoutesInfo) error {
+	documented := map[string]bool{}
+	for _, op := range operations {
+		documented[op.method+" "+op.path] = true
+	}
+
+	var missing []string
+	for _, route := range routes {
+		if !documented[route.Method+" "+route.Path] {
+			missing = append(missing, route.Method+" "+route.Path)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("routes missing from the OpenAPI document in spec.go: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func buildSpec() map[string]interface{} {
+	schemas := map[string]interface{}{
+		"Message": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"message": map[string]interface{}{"type": "string"}},
+		},
+	}
+
+	paths := map[string]interface{}{}
+	for _, op := range operations {
+		path, pathParams := openAPIPath(op.path)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+
+		var params []interface{}
+		for _, name := range pathParams {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, p := range op.query {
+			params = append(params, map[string]interface{}{
+				"name": p.name, "in": "query", "description": p.description,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+
+		responses := map[string]interface{}{}
+		for _, r := range op.responses {
+			resp := map[string]interface{}{"description": http.StatusText(r.status)}
+			if r.body != nil {
+				resp["content"] = bodyContent(r.body, schemas)
+			}
+			if len(r.headers) > 0 {
+				headers := map[string]interface{}{}
+				for name, description := range r.headers {
+					headers[name] = map[string]interface{}{
+						"description": description,
+						"schema":      map[string]interface{}{"type": "string"},
+					}
+				}
+				resp["headers"] = headers
+			}
+			responses[fmt.Sprint(r.status)] = resp
+		}
+
+		operation := map[string]interface{}{"summary": op.summary, "responses": responses}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  bodyContent(op.body, schemas),
+			}
+		}
+		item[strings.ToLower(op.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       map[string]interface{}{"title": specTitle, "version": "1.0.0"},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// openAPIPath turns a gin path like "/polls/:id" into "/polls/{id}" and
+// returns the names of its params
+func openAPIPath(path string) (string, []string) {
+	var names []string
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			names = append(names, part[1:])
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/"), names
+}
+
+// bodyContent describes a body like body, sent as JSON or, for a Problem,
+// as a problem document
+func bodyContent(body interface{}, schemas map[string]interface{}) map[string]interface{} {
+	contentType := "application/json"
+	if _, ok := body.(Problem); ok {
+		contentType = problemContentType
+	}
+	schema := schemaOf(reflect.TypeOf(body), schemas)
+	return map[string]interface{}{contentType: map[string]interface{}{"schema": schema}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf describes how encoding/json encodes values of type t. Named
+// structs are added to schemas and referenced.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(messageBody{}):
+		return map[string]interface{}{"$ref": "#/components/schemas/Message"}
+	case reflect.TypeOf(mergePatchBody{}):
+		return map[string]interface{}{"type": "object", "description": "JSON Merge Patch (RFC 7396) of the resource"}
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := schemaOf(t.Elem(), schemas)
+		if _, ok := schema["$ref"]; ok {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas), "nullable": true}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = nil // Stops recursive types from looping
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaOf(field.Type, schemas)
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
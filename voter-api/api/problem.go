@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every error is answered with an RFC 7807 problem document. Next to the
+// standard members it carries a code for programs to act on, as the title
+// and detail are meant for people and may change.
+
+const problemContentType = "application/problem+json"
+
+// Codes of the problems the APIs answer with
+const (
+	codeValidationFailed      = "validation_failed"      // 400, the request is wrong
+	codeNotFound              = "not_found"              // 404
+	codeConflict              = "conflict"               // 409, the request clashes with the current state
+	codeInternal              = "internal_error"         // 500
+	codeDependencyFailed      = "dependency_failed"      // 502, another API answered with an error
+	codeDependencyUnavailable = "dependency_unavailable" // 503, another API could not be reached
+)
+
+// Problem is the body of every error response. Handlers with more to say
+// embed it in a struct of their own.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"` // Set for validation_failed if it is down to particular fields
+}
+
+// FieldError says what is wrong with one field of the request
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func newProblem(c *gin.Context, status int, code string, detail string) Problem {
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: c.Request.URL.Path,
+		Code:     code,
+	}
+}
+
+// sendProblem answers with body, a Problem or a struct embedding one, and
+// stops the request there
+func sendProblem(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(status, body)
+}
+
+func badRequest(c *gin.Context, detail string) {
+	sendProblem(c, http.StatusBadRequest, newProblem(c, http.StatusBadRequest, codeValidationFailed, detail))
+}
+
+func notFound(c *gin.Context, detail string) {
+	sendProblem(c, http.StatusNotFound, newProblem(c, http.StatusNotFound, codeNotFound, detail))
+}
+
+func conflict(c *gin.Context, detail string) {
+	sendProblem(c, http.StatusConflict, newProblem(c, http.StatusConflict, codeConflict, detail))
+}
+
+func internalError(c *gin.Context, detail string) {
+	sendProblem(c, http.StatusInternalServerError, newProblem(c, http.StatusInternalServerError, codeInternal, detail))
+}
+
+// dependencyError answers for a failed call to another service: 503 if it
+// could not be reached, 502 otherwise
+func dependencyError(c *gin.Context, err error, detail string) {
+	status, code := http.StatusBadGateway, codeDependencyFailed
+	if errors.Is(err, errServiceUnavailable) {
+		status, code = http.StatusServiceUnavailable, codeDependencyUnavailable
+	}
+	sendProblem(c, status, newProblem(c, status, code, detail))
+}
+
+// NoRoute answers requests for paths no route matches
+func NoRoute(c *gin.Context) {
+	notFound(c, "No such endpoint: "+c.Request.Method+" "+c.Request.URL.Path)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+
+	"voter-api/schema"
+
+	"github.com/gin-gonic/gin"
+)
+
+const specTitle = "Voter API"
+
+// RegisterRoutes adds the API's routes to r. Each one needs an entry in
+// operations; spec_test.go checks that.
+func (p *VoterAPI) RegisterRoutes(r gin.IRouter) {
+	r.GET("/voters", p.GetAllVoters)
+	r.POST("/voters", p.PostVoter)
+	r.GET("/voters/:id", p.GetVoterByID)
+	r.PUT("/voters/:id", p.PutVoter)
+	r.PATCH("/voters/:id", p.PatchVoter)
+	r.DELETE("/voters/:id", p.DeleteVoter)
+	r.PUT("/voters/:id/history", p.PutVoteToVoteHistory)
+	r.GET("/voters/:id/history", p.GetVoteHistory)
+	r.PUT("/voters/:id/history/:voteid", p.PutVoteHistoryEntry)
+	r.DELETE("/voters/:id/history/:voteid", p.DeleteVoteFromVoteHistory)
+	r.GET("/openapi.json", OpenAPI)
+}
+
+// operations documents every route RegisterRoutes adds, for the OpenAPI
+// document
+var operations = []operation{
+	{
+		method:  http.MethodGet,
+		path:    "/voters",
+		summary: "List voters, page by page",
+		query: append(pageQuery("id, name"),
+			param{"lastName", "Only voters whose LastName starts with this, ignoring case"},
+			param{"firstName", "Only voters whose FirstName starts with this, ignoring case"},
+			param{"votedIn", "Only voters who voted in the poll with this ID"}),
+		responses: append([]response{pageResponse([]schema.Voter{})}, errorResponses(http.StatusBadRequest, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodPost,
+		path:      "/voters",
+		summary:   "Create a voter; a zero VoterID is picked by the API",
+		body:      schema.Voter{},
+		responses: append([]response{createdResponse(schema.Voter{}, "voter")}, errorResponses(http.StatusBadRequest, http.StatusConflict, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodGet,
+		path:      "/voters/:id",
+		summary:   "Get a voter",
+		responses: append([]response{okResponse(schema.Voter{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodPut,
+		path:      "/voters/:id",
+		summary:   "Replace a voter; a null VoteHistory keeps the stored one",
+		body:      schema.Voter{},
+		responses: append([]response{okResponse(schema.Voter{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodPatch,
+		path:      "/voters/:id",
+		summary:   "Change part of a voter with a JSON Merge Patch",
+		body:      mergePatchBody{},
+		responses: append([]response{okResponse(schema.Voter{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		method:  http.MethodDelete,
+		path:    "/voters/:id",
+		summary: "Delete a voter; one who voted needs anonymize",
+		query:   []param{{"anonymize", "true keeps the voter's votes without the voter"}},
+		responses: append([]response{okResponse(messageBody{})},
+			errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodGet,
+		path:      "/voters/:id/history",
+		summary:   "Get the voter's VoteHistory",
+		responses: append([]response{okResponse([]schema.VoteHistoryEntry{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodPut,
+		path:      "/voters/:id/history",
+		summary:   "Add an entry to the voter's VoteHistory; votes-api does this when a vote is cast",
+		query:     []param{{"poll", "ID of the vote's poll, for bodies that are a plain vote link"}},
+		body:      schema.VoteHistoryEntry{},
+		responses: append([]response{okResponse(messageBody{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodPut,
+		path:      "/voters/:id/history/:voteid",
+		summary:   "Replace the VoteHistory entry of a vote; votes-api does this when a vote changes",
+		body:      schema.VoteHistoryEntry{},
+		responses: append([]response{okResponse([]schema.VoteHistoryEntry{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodDelete,
+		path:      "/voters/:id/history/:voteid",
+		summary:   "Take a vote out of the voter's VoteHistory",
+		responses: append([]response{okResponse(messageBody{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodGet,
+		path:      "/openapi.json",
+		summary:   "This document",
+		responses: append([]response{okResponse(map[string]interface{}{})}, errorResponses(http.StatusInternalServerError)...),
+	},
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-playground/validator/v10/non-standard/validators"
+)
+
+// Request bodies are checked against the `binding` rules declared on the
+// schema types. A body that breaks them is answered with a problem listing
+// every invalid field and why.
+
+func init() {
+	if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		// "required" accepts a string of spaces, "notblank" does not
+		if err := engine.RegisterValidation("notblank", validators.NotBlank); err != nil {
+			log.Fatal(err)
+		}
+		if err := engine.RegisterValidation("idlink", isIDOrLink); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// isIDOrLink is the "idlink" rule: the field is an ID like "1" or a link
+// like "/polls/1", for idlink=polls
+func isIDOrLink(field validator.FieldLevel) bool {
+	value := strings.TrimPrefix(field.Field().String(), "/"+field.Param()+"/")
+	_, err := strconv.ParseUint(value, 10, 64)
+	return err == nil
+}
+
+// bindJSON reads the request body into obj and checks its rules, answering
+// with 400 if the body does not fit
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		log.Println("Error binding JSON: ", err)
+		invalidBody(c, err)
+		return false
+	}
+	return true
+}
+
+// validate checks the rules of a value that was not bound from a request
+// body as is, e.g. the result of a JSON Merge Patch
+func validate(obj interface{}) error {
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// isInvalid reports whether err came from validate
+func isInvalid(err error) bool {
+	var invalid validator.ValidationErrors
+	return errors.As(err, &invalid)
+}
+
+// invalidBody answers with the problem err, from bindJSON or validate,
+// found in the request body
+func invalidBody(c *gin.Context, err error) {
+	problem := newProblem(c, http.StatusBadRequest, codeValidationFailed, "")
+
+	var invalid validator.ValidationErrors
+	var wrongType *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &invalid):
+		problem.Detail = "The body has invalid fields"
+		for _, field := range invalid {
+			problem.Errors = append(problem.Errors, FieldError{Field: fieldPath(field), Reason: fieldReason(field)})
+		}
+	case errors.As(err, &wrongType) && wrongType.Field != "":
+		problem.Detail = "The body has invalid fields"
+		reason := fmt.Sprintf("must be %s, not a JSON %s", jsonKind(wrongType.Type), wrongType.Value)
+		problem.Errors = []FieldError{{Field: jsonFieldPath(wrongType.Field), Reason: reason}}
+	default:
+		problem.Detail = "The body is not valid JSON: " + err.Error()
+	}
+	sendProblem(c, http.StatusBadRequest, problem)
+}
+
+// fieldPath names the field as it appears in the body, e.g.
+// "PollOptions[1].PollOptionText"
+func fieldPath(field validator.FieldError) string {
+	path := field.Namespace()
+	if i := strings.Index(path, "."); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+// jsonFieldPath writes the path encoding/json reports, e.g.
+// "PollOptions.1.PollOptionText", the way fieldPath does
+func jsonFieldPath(path string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(part); err == nil && i > 0 {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+func fieldReason(field validator.FieldError) string {
+	counted := field.Kind() == reflect.Slice || field.Kind() == reflect.Map
+	switch field.Tag() {
+	case "required":
+		return "is required"
+	case "notblank":
+		return "must not be blank"
+	case "min":
+		if counted && field.Param() == "1" {
+			return "must not be empty"
+		} else if counted {
+			return fmt.Sprintf("must have at least %s entries", field.Param())
+		} else if field.Kind() == reflect.String {
+			return fmt.Sprintf("must be at least %s characters long", field.Param())
+		}
+		return "must be at least " + field.Param()
+	case "max":
+		if counted {
+			return fmt.Sprintf("must have at most %s entries", field.Param())
+		} else if field.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters long", field.Param())
+		}
+		return "must be at most " + field.Param()
+	case "unique":
+		if field.Param() != "" {
+			return "must not have two entries with the same " + field.Param()
+		}
+		return "must not have the same value twice"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(field.Param(), " ", ", ")
+	case "startswith":
+		return "must start with " + field.Param()
+	case "idlink":
+		return fmt.Sprintf("must be an ID like \"1\" or a link like \"/%s/1\"", field.Param())
+	}
+	return "breaks the " + field.Tag() + " rule"
+}
+
+// jsonKind names what a JSON value of Go type t looks like
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number of at least 0"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
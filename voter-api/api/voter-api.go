@@ -1,236 +1,473 @@
 package api
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"voter-api/schema"
+	"voter-api/store"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis/v8"
-	"github.com/nitishm/go-rejson/v4"
 )
 
-const (
-	RedisNilError        = "redis: nil"
-	RedisDefaultLocation = "0.0.0.0:6379"
-)
-
-type cache struct {
-	client  *redis.Client
-	helper  *rejson.Handler
-	context context.Context
-}
+var errInvalidVoter = errors.New("invalid voter")
 
 type VoterAPI struct {
-	cache
+	voters   store.VoterRepository
+	votesAPI *serviceClient
 }
 
-func NewVoterAPI(location string) (*VoterAPI, error) {
-	//Connect to redis.  Other options can be provided, but the defaults are OK
-	client := redis.NewClient(&redis.Options{
-		Addr: location,
-	})
-
-	ctx := context.Background()
+func NewVoterAPI(voters store.VoterRepository, votesAPIURL string) *VoterAPI {
+	//Return a pointer to a new Voter struct
+	return &VoterAPI{
+		voters:   voters,
+		votesAPI: newServiceClient("votes-api", votesAPIURL),
+	}
+}
 
-	//Ensure that our redis connection is working
-	err := client.Ping(ctx).Err()
+// voterIDParam reads the :id param, responding with 400 if it is not a
+// valid voter ID
+func voterIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
-		log.Println("Error connecting to redis" + err.Error())
-		return nil, err
+		badRequest(c, "Invalid voter ID: "+c.Param("id"))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// pageParams reads the ?limit=&cursor=&sort= query params of a list
+// endpoint, responding with 400 if they are not valid. sort takes "id" or
+// one of the given orders, prefixed with "-" for descending order.
+func pageParams(c *gin.Context, sorts ...string) (store.Page, bool) {
+	page := store.Page{Cursor: c.Query("cursor"), Limit: store.DefaultPageLimit}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > store.MaxPageLimit {
+			msg := fmt.Sprintf("limit must be a number between 1 and %d", store.MaxPageLimit)
+			badRequest(c, msg)
+			return page, false
+		}
+		page.Limit = n
 	}
 
-	jsonHelper := rejson.NewReJSONHandler()
-	jsonHelper.SetGoRedisClientWithContext(ctx, client)
+	if sort := c.Query("sort"); sort != "" {
+		page.Desc = strings.HasPrefix(sort, "-")
+		page.Sort = strings.TrimPrefix(sort, "-")
+		valid := page.Sort == store.SortByID
+		for _, allowed := range sorts {
+			valid = valid || page.Sort == allowed
+		}
+		if !valid {
+			orders := strings.Join(append([]string{store.SortByID}, sorts...), ", ")
+			msg := fmt.Sprintf("sort must be one of %s, optionally prefixed with -", orders)
+			badRequest(c, msg)
+			return page, false
+		}
+	}
+	return page, true
+}
 
-	//Return a pointer to a new Voter struct
-	return &VoterAPI{
-		cache: cache{
-			client:  client,
-			helper:  jsonHelper,
-			context: ctx,
-		},
-	}, nil
+// setNextCursor tells the client where the next page starts. The body stays
+// a plain list so existing clients keep working.
+func setNextCursor(c *gin.Context, next string) {
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+	}
 }
 
 func (p *VoterAPI) PostVoter(c *gin.Context) {
 	var newVoter schema.Voter
 
-	if err := c.ShouldBindJSON(&newVoter); err != nil {
-		log.Println("Error binding JSON: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+	if !bindJSON(c, &newVoter) {
 		return
 	}
 
-	VoterJSON, err := json.Marshal(newVoter)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize Voter data"})
+	// Check if the vote history is empty
+	if len(newVoter.VoteHistory) != 0 {
+		badRequest(c, "New voters cannot have previous votes.")
 		return
 	}
 
-	voterKey := fmt.Sprintf("voter-%d", newVoter.VoterID)
+	// Voters posted without a VoterID get the next free one. IDs clients
+	// picked themselves are skipped.
+	allocate := newVoter.VoterID == 0
+	for {
+		if allocate {
+			id, err := p.voters.NextID(c)
+			if err != nil {
+				internalError(c, "Failed to allocate a voter ID")
+				return
+			}
+			newVoter.VoterID = id
+		}
 
-	// Check if the id already exists
-	ks, _ := p.client.Keys(p.context, "voter-*").Result()
-	for _, key := range ks {
-		if voterKey == key {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Voter already exists (ID is not unique)"})
+		err := p.voters.Create(c, newVoter)
+		if err == store.ErrExists && allocate {
+			continue
+		} else if err == store.ErrExists {
+			conflict(c, fmt.Sprintf("Voter %d already exists", newVoter.VoterID))
+			return
+		} else if err != nil {
+			internalError(c, "Failed to store Voter in cache")
 			return
 		}
+		break
 	}
 
-	// Check if the vote history is empty
-	if len(newVoter.VoteHistory) != 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "New voters cannot have previous votes."})
+	c.Header("Location", fmt.Sprintf("/voters/%d", newVoter.VoterID))
+	c.JSON(http.StatusCreated, newVoter)
+}
+
+// PutVoter replaces the voter in the :id param with the request body
+func (p *VoterAPI) PutVoter(c *gin.Context) {
+	id, ok := voterIDParam(c)
+	if !ok {
 		return
 	}
 
-	err = p.client.Set(p.context, voterKey, VoterJSON, 0).Err()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store Voter in cache"})
+	var newVoter schema.Voter
+	if !bindJSON(c, &newVoter) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Voter added to cache successfully"})
+	p.replaceVoter(c, id, func(current schema.Voter) (schema.Voter, error) {
+		if newVoter.VoteHistory == nil {
+			newVoter.VoteHistory = current.VoteHistory
+		}
+		return newVoter, nil
+	})
 }
 
-func (p *VoterAPI) GetVoterByID(c *gin.Context) {
-	id := c.Param("id")
-	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No voter ID provided"})
+// PatchVoter applies the JSON Merge Patch in the request body to the voter
+// in the :id param
+func (p *VoterAPI) PatchVoter(c *gin.Context) {
+	id, ok := voterIDParam(c)
+	if !ok {
+		return
+	}
+
+	var patch interface{}
+	if err := json.NewDecoder(c.Request.Body).Decode(&patch); err != nil {
+		badRequest(c, "The body must be a JSON Merge Patch: "+err.Error())
 		return
 	}
+	if _, ok := patch.(map[string]interface{}); !ok {
+		badRequest(c, "The body must be a JSON object")
+		return
+	}
+
+	p.replaceVoter(c, id, func(current schema.Voter) (schema.Voter, error) {
+		var document interface{}
+		currentJSON, _ := json.Marshal(current)
+		if err := json.Unmarshal(currentJSON, &document); err != nil {
+			return current, err
+		}
+
+		var patched schema.Voter
+		patchedJSON, _ := json.Marshal(mergePatch(document, patch))
+		if err := json.Unmarshal(patchedJSON, &patched); err != nil {
+			return current, fmt.Errorf("%w: %v", errInvalidVoter, err)
+		}
+		return patched, validate(patched)
+	})
+}
+
+// replaceVoter stores the version of the voter build makes out of the
+// current one. The VoteHistory is kept in step with the votes by votes-api,
+// so it cannot be changed here.
+func (p *VoterAPI) replaceVoter(c *gin.Context, id uint, build func(current schema.Voter) (schema.Voter, error)) {
+	voterItem, err := p.voters.Update(c, id, func(voter *schema.Voter) error {
+		next, err := build(*voter)
+		if err != nil {
+			return err
+		}
+
+		if next.VoterID == 0 {
+			next.VoterID = id
+		} else if next.VoterID != id {
+			return fmt.Errorf("%w: VoterID cannot change", errInvalidVoter)
+		}
+		if !sameHistory(next.VoteHistory, voter.VoteHistory) {
+			return fmt.Errorf("%w: VoteHistory cannot be changed directly", errInvalidVoter)
+		}
+
+		*voter = next
+		return nil
+	})
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Voter %d does not exist", id))
+		return
+	} else if isInvalid(err) {
+		invalidBody(c, err)
+		return
+	} else if errors.Is(err, errInvalidVoter) {
+		badRequest(c, err.Error())
+		return
+	} else if err != nil {
+		internalError(c, "Failed to store Voter in cache")
+		return
+	}
+
+	c.JSON(http.StatusOK, voterItem)
+}
+
+// sameHistory compares two VoteHistories the way they are stored
+func sameHistory(a []schema.VoteHistoryEntry, b []schema.VoteHistoryEntry) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return string(aJSON) == string(bJSON)
+}
 
-	var voterItem schema.Voter
-	value, err := p.client.Get(c, "voter-"+id).Result()
-	if err == redis.Nil {
-		notExistMsg := fmt.Sprintf("Key %s does not exist in Redis", id)
-		c.JSON(http.StatusBadRequest, gin.H{"error": notExistMsg})
+// DeleteVoter deletes the voter in the :id param. A voter who voted is only
+// deleted with ?anonymize=true, which detaches the voter from their votes
+// in votes-api so poll results stay the same. The voter is deleted first so
+// they cannot vote again once their ballots are given back. votes-api queues
+// the voter beforehand, so its reconciler finishes if anonymizing fails.
+func (p *VoterAPI) DeleteVoter(c *gin.Context) {
+	id, ok := voterIDParam(c)
+	if !ok {
+		return
+	}
+
+	if _, err := p.voters.Get(c, id); err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Voter %d does not exist", id))
 		return
 	} else if err != nil {
-		log.Fatalf("Error getting key %s: %v", id, err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error getting key"})
+		log.Printf("Error getting voter %d: %v", id, err)
+		internalError(c, "Error getting voter")
 		return
+	}
+
+	anonymize := c.Query("anonymize") == "true"
+	if anonymize {
+		if err := p.queueAnonymize(c.Request.Context(), id); err != nil {
+			log.Printf("Error queueing voter %d to be anonymized: %v", id, err)
+			dependencyError(c, err, "Failed to anonymize the voter's votes")
+			return
+		}
 	} else {
-		valueBytes := []byte(value)
-		err = json.Unmarshal(valueBytes, &voterItem)
+		hasVotes, err := p.hasVotes(c.Request.Context(), id)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find voter in cache with id=" + id})
+			log.Printf("Error checking the votes of voter %d: %v", id, err)
+			dependencyError(c, err, "Could not check the voter's votes")
+			return
+		}
+		if hasVotes {
+			msg := fmt.Sprintf("Voter %d has votes: pass anonymize=true to keep them without the voter", id)
+			conflict(c, msg)
 			return
 		}
+	}
 
-		c.JSON(http.StatusOK, voterItem)
+	err := p.voters.Delete(c, id)
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Voter %d does not exist", id))
+		return
+	} else if err != nil {
+		internalError(c, "Failed to delete Voter from cache")
 		return
 	}
+
+	if anonymize {
+		if err := p.anonymizeVotes(c.Request.Context(), id); err != nil {
+			log.Printf("Failed to anonymize the votes of voter %d, leaving it to the reconciler: %v", id, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Voter deleted successfully"})
 }
 
-func (p *VoterAPI) GetAllVoters(c *gin.Context) {
-	var voterList []schema.Voter
-	var voterItem schema.Voter
+func (p *VoterAPI) GetVoterByID(c *gin.Context) {
+	id, ok := voterIDParam(c)
+	if !ok {
+		return
+	}
+
+	voterItem, err := p.voters.Get(c, id)
+	if err == store.ErrNotFound {
+		notExistMsg := fmt.Sprintf("Voter %d does not exist", id)
+		notFound(c, notExistMsg)
+		return
+	} else if err != nil {
+		log.Printf("Error getting voter %d: %v", id, err)
+		internalError(c, "Error getting voter")
+		return
+	}
 
-	pattern := "voter-*"
-	ks, _ := p.client.Keys(p.context, pattern).Result()
+	c.JSON(http.StatusOK, voterItem)
+}
 
-	for _, key := range ks {
-		value, err := p.client.Get(c, key).Result()
-		if err == redis.Nil {
-			fmt.Printf("Key %s does not exist in Redis\n", key)
-		} else if err != nil {
-			log.Fatalf("Error getting key %s: %v", key, err)
-		} else {
-			valueBytes := []byte(value)
-			err = json.Unmarshal(valueBytes, &voterItem)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find voter in cache with id=" + key})
-			}
+func (p *VoterAPI) GetAllVoters(c *gin.Context) {
+	page, ok := pageParams(c, store.SortByName)
+	if !ok {
+		return
+	}
 
-			voterList = append(voterList, voterItem)
-		}
+	filter := store.VoterFilter{
+		LastName:  c.Query("lastName"),
+		FirstName: c.Query("firstName"),
+		VotedIn:   c.Query("votedIn"),
+	}
+	voterList, next, err := p.voters.List(c, filter, page)
+	if err == store.ErrInvalidCursor {
+		badRequest(c, "Invalid cursor: "+page.Cursor)
+		return
+	} else if err != nil {
+		log.Println("Error listing voters: " + err.Error())
+		internalError(c, "Could not list voters")
+		return
 	}
 
+	setNextCursor(c, next)
 	c.JSON(http.StatusOK, voterList)
 }
 
 func (p *VoterAPI) GetVoteHistory(c *gin.Context) {
-	id := c.Param("id")
-	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No ID provided"})
+	id, ok := voterIDParam(c)
+	if !ok {
 		return
 	}
 
-	var voterItem schema.Voter
-	value, err := p.client.Get(c, "voter-"+id).Result()
-	if err == redis.Nil {
-		fmt.Printf("Key %s does not exist in Redis\n", id)
+	history, err := p.voters.History(c, id)
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Voter %d does not exist", id))
+		return
 	} else if err != nil {
-		log.Fatalf("Error getting key %s: %v", id, err)
-	} else {
-		fmt.Printf("Value for key %s: %s\n", id, value)
-		valueBytes := []byte(value)
-		err = json.Unmarshal(valueBytes, &voterItem)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find publication in cache with id=" + id})
-			return
-		}
-
-		c.JSON(http.StatusOK, voterItem.VoteHistory)
+		log.Printf("Error getting voter %d: %v", id, err)
+		internalError(c, "Error getting voter")
+		return
 	}
+
+	c.JSON(http.StatusOK, history)
 }
 
 func (p *VoterAPI) PutVoteToVoteHistory(c *gin.Context) {
 	// Make sure the voter exists (using the :id)
-	id := c.Param("id")
-	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No ID provided"})
+	id, ok := voterIDParam(c)
+	if !ok {
 		return
 	}
 
-	// Get the voter
-	var voterItem schema.Voter
-
-	value, err := p.client.Get(c, "voter-"+id).Result()
+	// The payload is a VoteHistoryEntry. Older votes-api versions send the
+	// plain vote link and say which poll it was cast in with ?poll=.
+	payload, _ := io.ReadAll(c.Request.Body)
+	var entry schema.VoteHistoryEntry
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		entry = schema.VoteHistoryEntry{VoteLink: string(payload)}
+	}
+	if poll := c.Query("poll"); entry.PollLink == "" && poll != "" {
+		entry.PollLink = "/polls/" + poll
+	}
+	if err := validate(entry); err != nil {
+		invalidBody(c, err)
+		return
+	}
 
-	if err == redis.Nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Voter does not exist in Redis"})
+	voterItem, err := p.voters.AddToHistory(c, id, entry)
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Voter %d does not exist", id))
+		return
+	} else if err == store.ErrInHistory {
+		conflict(c, entry.VoteLink+" is already in the voter's VoteHistory")
+		return
 	} else if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error getting the voter"})
-	} else {
-		valueBytes := []byte(value)
-		err = json.Unmarshal(valueBytes, &voterItem)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find voter in cache with id=" + id})
-		}
+		internalError(c, "Failed to store Voter in cache")
+		return
+	}
 
-		// Read the payload from the request body (assuming it's a string)
-		payload, _ := io.ReadAll(c.Request.Body)
+	VoterJSON, _ := json.Marshal(voterItem)
+	log.Println("Payload: \n" + string(payload))
+	log.Println("VoterJSON: \n" + string(VoterJSON))
 
-		// Add the payload to the voter's VoteHistory
-		voterItem.VoteHistory = append(voterItem.VoteHistory, string(payload))
+	c.JSON(http.StatusOK, gin.H{"message": "Vote added to the voter's VoteHistory successfully: " + string(VoterJSON)})
+}
 
-		VoterJSON, err := json.Marshal(voterItem)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to Marshall the voter"})
-			return
-		}
-		log.Println("Payload: \n" + string(payload))
-		log.Println("VoterJSON: \n" + string(VoterJSON))
+// PutVoteHistoryEntry replaces the entry for /votes/:voteid in the voter's
+// VoteHistory. votes-api calls it when a vote is changed; the entry keeps
+// pointing at the same vote and poll.
+func (p *VoterAPI) PutVoteHistoryEntry(c *gin.Context) {
+	id, ok := voterIDParam(c)
+	if !ok {
+		return
+	}
+	voteLink := "/votes/" + c.Param("voteid")
 
-		err = p.client.Set(p.context, "voter-"+id, VoterJSON, 0).Err()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store Voter in cache"})
-			return
-		}
-		value, err := p.client.Get(c, "voter-"+id).Result()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store Voter in cache"})
-			return
+	// The entry may leave out its VoteLink, so it is checked once that is
+	// filled in
+	var entry schema.VoteHistoryEntry
+	if err := json.NewDecoder(c.Request.Body).Decode(&entry); err != nil {
+		invalidBody(c, err)
+		return
+	}
+	if entry.VoteLink == "" {
+		entry.VoteLink = voteLink
+	}
+	if err := validate(entry); err != nil {
+		invalidBody(c, err)
+		return
+	}
+
+	voterItem, err := p.voters.Update(c, id, func(voter *schema.Voter) error {
+		for i, current := range voter.VoteHistory {
+			if current.VoteLink != voteLink {
+				continue
+			}
+			if entry.PollLink == "" {
+				entry.PollLink = current.PollLink
+			}
+			if entry.VoteLink != voteLink || entry.PollLink != current.PollLink {
+				return fmt.Errorf("%w: an entry cannot move to another vote or poll", errInvalidVoter)
+			}
+			voter.VoteHistory[i] = entry
+			return nil
 		}
-		c.JSON(http.StatusOK, gin.H{"message": "Vote added to the voter's VoteHistory successfully: " + string(value)})
+		return store.ErrNotInHistory
+	})
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Voter %d does not exist", id))
+		return
+	} else if err == store.ErrNotInHistory {
+		notFound(c, voteLink+" is not in the voter's VoteHistory")
+		return
+	} else if errors.Is(err, errInvalidVoter) {
+		badRequest(c, err.Error())
+		return
+	} else if err != nil {
+		internalError(c, "Failed to update the voter's VoteHistory")
+		return
+	}
+
+	c.JSON(http.StatusOK, voterItem.VoteHistory)
+}
+
+// DeleteVoteFromVoteHistory removes /votes/:voteid from the voter's
+// VoteHistory. votes-api calls it to undo a history entry when storing the
+// vote itself fails.
+func (p *VoterAPI) DeleteVoteFromVoteHistory(c *gin.Context) {
+	id, ok := voterIDParam(c)
+	if !ok {
+		return
+	}
+	voteLink := "/votes/" + c.Param("voteid")
+
+	err := p.voters.RemoveFromHistory(c, id, voteLink)
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Voter %d does not exist", id))
+		return
+	} else if err == store.ErrNotInHistory {
+		notFound(c, voteLink+" is not in the voter's VoteHistory")
+		return
+	} else if err != nil {
+		internalError(c, "Failed to update the voter's VoteHistory")
 		return
 	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vote removed from the voter's VoteHistory successfully"})
 }
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"voter-api/schema"
+)
+
+// hasVotes asks votes-api whether the voter cast any vote
+func (p *VoterAPI) hasVotes(ctx context.Context, id uint) (bool, error) {
+	resp, err := p.votesAPI.do(ctx, http.MethodGet, fmt.Sprintf("/votes?voter=%d&limit=1", id), nil)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, p.votesAPI.unexpected(resp, fmt.Sprintf("listing the votes of voter %d", id))
+	}
+	var votes []json.RawMessage
+	if err := resp.decode(&votes); err != nil {
+		return false, err
+	}
+	return len(votes) > 0, nil
+}
+
+// historyVote is the part of a votes-api vote a VoteHistoryEntry describes
+type historyVote struct {
+	PollID         string
+	VoteValue      uint
+	VoteRanking    []uint
+	VoteSelections []uint
+	VoteScores     []struct{ PollOptionID uint }
+	CastAt         *time.Time
+}
+
+// DescribeVote fills in the poll, date and options of a VoteHistoryEntry
+// from the vote in votes-api. Entries whose vote cannot be read are left as
+// they are.
+func (p *VoterAPI) DescribeVote(entry *schema.VoteHistoryEntry) {
+	resp, err := p.votesAPI.do(context.Background(), http.MethodGet, entry.VoteLink, nil)
+	if err != nil {
+		return
+	}
+
+	var vote historyVote
+	if resp.StatusCode != http.StatusOK || resp.decode(&vote) != nil {
+		return
+	}
+	if entry.PollLink == "" {
+		entry.PollLink = vote.PollID
+	}
+	entry.VoteDate = vote.CastAt
+	switch {
+	case len(vote.VoteRanking) > 0:
+		entry.Options = vote.VoteRanking
+	case len(vote.VoteSelections) > 0:
+		entry.Options = vote.VoteSelections
+	case len(vote.VoteScores) > 0:
+		entry.Options = nil
+		for _, score := range vote.VoteScores {
+			entry.Options = append(entry.Options, score.PollOptionID)
+		}
+	default:
+		entry.Options = []uint{vote.VoteValue}
+	}
+}
+
+// queueAnonymize asks votes-api to anonymize the voter's votes later if
+// anonymizeVotes is not called once the voter is deleted
+func (p *VoterAPI) queueAnonymize(ctx context.Context, id uint) error {
+	resp, err := p.votesAPI.do(ctx, http.MethodPost, fmt.Sprintf("/voters/%d/anonymize-queue", id), nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return p.votesAPI.unexpected(resp, fmt.Sprintf("queueing voter %d to be anonymized", id))
+	}
+	return nil
+}
+
+// anonymizeVotes asks votes-api to detach the voter from every vote they
+// cast. The votes keep counting in their polls' results. Anonymizing twice
+// changes nothing, so the POST is safe to retry.
+func (p *VoterAPI) anonymizeVotes(ctx context.Context, id uint) error {
+	resp, err := p.votesAPI.do(ctx, http.MethodPost, fmt.Sprintf("/voters/%d/anonymize", id), nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return p.votesAPI.unexpected(resp, fmt.Sprintf("anonymizing the votes of voter %d", id))
+	}
+	return nil
+}
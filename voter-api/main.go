@@ -1,30 +1,34 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"voter-api/api"
+	"voter-api/store"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 var (
-	hostFlag string
-	portFlag uint
-	cacheURL string
+	hostFlag  string
+	portFlag  uint
+	cacheURL  string
+	storeFlag string
 	// voterAPIURL string
-	// votesAPIURL string
+	votesAPIURL string
 )
 
 func processCmdLineFlags() {
 	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
 	flag.StringVar(&cacheURL, "c", "0.0.0.0:6379", "Default cache location")
+	flag.StringVar(&storeFlag, "store", "redis", "Storage backend: redis or memory")
 	// flag.StringVar(&voterAPIURL, "voterapi", "http://localhost:1080", "Default endpoint for voter API")
-	// flag.StringVar(&votesAPIURL, "votesapi", "http://localhost:3080", "Default endpoint for votes API")
+	flag.StringVar(&votesAPIURL, "votesapi", "http://localhost:3080", "Default endpoint for votes API")
 	flag.UintVar(&portFlag, "p", 1080, "Default Port")
 
 	flag.Parse()
@@ -44,8 +48,9 @@ func setupParms() {
 
 	//now process any environment variables
 	cacheURL = envVarOrDefault("REDIS_URL", cacheURL)
+	storeFlag = envVarOrDefault("STORE_BACKEND", storeFlag)
 	// voterAPIURL = envVarOrDefault("ELECTION_VOTER_API_URL", voterAPIURL)
-	// votesAPIURL = envVarOrDefault("ELECTION_VOTES_API_URL", votesAPIURL)
+	votesAPIURL = envVarOrDefault("ELECTION_VOTES_API_URL", votesAPIURL)
 	hostFlag = envVarOrDefault("POLL_API_HOST", hostFlag)
 
 	pfNew, err := strconv.Atoi(envVarOrDefault("ELECTION_API_PORT", fmt.Sprintf("%d", portFlag)))
@@ -57,29 +62,64 @@ func setupParms() {
 
 }
 
+// newVoterRepository picks the storage backend. The memory backend lets the
+// service run without Redis, but voters only live as long as the process.
+func newVoterRepository() (store.VoterRepository, error) {
+	switch storeFlag {
+	case "redis":
+		return store.NewRedisVoterRepository(cacheURL)
+	case "memory":
+		return store.NewMemoryVoterRepository(), nil
+	}
+	return nil, fmt.Errorf("unknown store backend %q (expected redis or memory)", storeFlag)
+}
+
+func runMigrate(args []string) {
+	voters, err := store.NewRedisVoterRepository(cacheURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Entries converted from vote links are filled in from votes-api
+	describe := api.NewVoterAPI(voters, votesAPIURL).DescribeVote
+	if err := store.RunMigrateCommand(context.Background(), voters.Migrator(describe), args); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func main() {
 	//this will allow the user to override key parameters and also setup defaults
 	setupParms()
 	log.Println("Init/cacheURL: " + cacheURL)
+	log.Println("Init/store: " + storeFlag)
 	// log.Println("Init/VOTERAPIURL: " + voterAPIURL)
-	// log.Println("Init/VOTESAPIURL: " + votesAPIURL)
+	log.Println("Init/VOTESAPIURL: " + votesAPIURL)
 	log.Println("Init/hostFlag: " + hostFlag)
 	log.Printf("Init/portFlag: %d", portFlag)
 
-	apiHandler, err := api.NewVoterAPI(cacheURL)
+	// "voter-api migrate ..." migrates the voters stored in Redis and exits
+	if flag.Arg(0) == "migrate" {
+		runMigrate(flag.Args()[1:])
+		return
+	}
+
+	voters, err := newVoterRepository()
 
 	if err != nil {
 		panic(err)
 	}
 
+	apiHandler := api.NewVoterAPI(voters, votesAPIURL)
+
 	r := gin.Default()
 	r.Use(cors.Default())
 
-	r.GET("/voters", apiHandler.GetAllVoters)
-	r.POST("/voters", apiHandler.PostVoter)
-	r.GET("/voters/:id", apiHandler.GetVoterByID)
-	r.PUT("/voters/:id/history", apiHandler.PutVoteToVoteHistory)
-	r.GET("/voters/:id/history", apiHandler.GetVoteHistory)
+	apiHandler.RegisterRoutes(r)
+	r.NoRoute(api.NoRoute)
+
+	// spec_test.go keeps the OpenAPI document complete, this only warns
+	if err := api.CheckSpec(r.Routes()); err != nil {
+		log.Println("Warning: " + err.Error())
+	}
 	// We may need more???
 
 	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
@@ -1,20 +1,38 @@
 package schema
 
-// type voterPoll struct {
-// 	PollID   uint
-// 	VoteDate time.Time
-// }
+import (
+	"encoding/json"
+	"time"
+)
 
-// type Voter struct {
-// 	VoterID     uint
-// 	FirstName   string
-// 	LastName    string
-// 	VoteHistory []voterPoll
-// }
+// The `binding` rules are checked on every voter and VoteHistory entry sent
+// to the API
+
+// VoteHistoryEntry records a vote the voter cast
+type VoteHistoryEntry struct {
+	PollLink string     `binding:"omitempty,startswith=/polls/"` // The poll the vote was cast in, e.g. "/polls/1"
+	VoteLink string     `binding:"required,startswith=/votes/"`  // The vote itself, e.g. "/votes/1"
+	VoteDate *time.Time // When the vote was cast
+	// The PollOptionIDs the vote chose: the option voted for, the ranking
+	// in order of preference, the selected or the rated options
+	Options []uint
+}
+
+// UnmarshalJSON also reads the plain vote links VoteHistory held before it
+// had structured entries, so voters stored before the migration still load
+func (e *VoteHistoryEntry) UnmarshalJSON(data []byte) error {
+	var link string
+	if err := json.Unmarshal(data, &link); err == nil {
+		*e = VoteHistoryEntry{VoteLink: link}
+		return nil
+	}
+	type entry VoteHistoryEntry
+	return json.Unmarshal(data, (*entry)(e))
+}
 
 type Voter struct {
-	VoterID     uint // Change to Link
-	FirstName   string
-	LastName    string
-	VoteHistory []string //  Change to Link
+	VoterID     uint               // Change to Link
+	FirstName   string             `binding:"notblank"`
+	LastName    string             `binding:"notblank"`
+	VoteHistory []VoteHistoryEntry `binding:"dive"`
 }
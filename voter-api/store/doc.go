@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nitishm/go-rejson/v4"
+)
+
+// Entities are stored as RedisJSON documents when Redis has the RedisJSON
+// module, so parts of them can be read and changed in place, and as plain
+// string values otherwise. Values stored as strings before RedisJSON was
+// used are converted by a migration; until then reads accept both and
+// writes replace them.
+
+var errNoRedisJSON = errors.New("Redis does not have the RedisJSON module")
+
+// processor runs a command: the client, a transaction or a pipeline
+type processor interface {
+	Process(ctx context.Context, cmd redis.Cmder) error
+}
+
+// docStore reads and writes the JSON documents entities are stored as
+type docStore struct {
+	client *redis.Client
+	helper *rejson.Handler
+	json   bool // Whether Redis has the RedisJSON module
+}
+
+func newDocStore(ctx context.Context, client *redis.Client) docStore {
+	jsonHelper := rejson.NewReJSONHandler()
+	jsonHelper.SetGoRedisClientWithContext(ctx, client)
+
+	// Without the module JSON.GET is an unknown command
+	err := client.Do(ctx, "JSON.GET", "rejson-probe").Err()
+	d := docStore{client: client, helper: jsonHelper, json: err == nil || err == redis.Nil}
+	log.Printf("Init/RedisJSON: %v", d.json)
+	return d
+}
+
+// isWrongType reports whether a command failed because the key holds the
+// other kind of value, e.g. a string not yet converted to a document
+func isWrongType(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
+
+// get reads the document under key through c, which may be a transaction
+// with the key WATCHed, returning redis.Nil if there is none
+func (d docStore) get(ctx context.Context, c processor, key string) ([]byte, error) {
+	if d.json {
+		cmd := redis.NewStringCmd(ctx, "JSON.GET", key)
+		_ = c.Process(ctx, cmd)
+		value, err := cmd.Bytes()
+		if !isWrongType(err) {
+			return value, err
+		}
+	}
+	cmd := redis.NewStringCmd(ctx, "GET", key)
+	_ = c.Process(ctx, cmd)
+	return cmd.Bytes()
+}
+
+// getPath reads part of the document under key, e.g. ".VoteHistory". Without
+// RedisJSON, or for values still stored as strings, it returns ok false and
+// the caller reads the whole document instead.
+func (d docStore) getPath(ctx context.Context, key string, path string) ([]byte, bool, error) {
+	if !d.json {
+		return nil, false, nil
+	}
+	res, err := d.helper.JSONGet(key, path)
+	if isWrongType(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, true, err
+	}
+	value, _ := res.([]byte)
+	return value, true, nil
+}
+
+// mget reads the documents under keys in one round trip, like MGET: missing
+// ones come back as nil, the others as strings
+func (d docStore) mget(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if !d.json {
+		return d.client.MGet(ctx, keys...).Result()
+	}
+
+	res, err := d.helper.JSONMGet(".", keys...)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := res.([]interface{})
+	for i, value := range values {
+		if doc, ok := value.([]byte); ok {
+			values[i] = string(doc)
+			continue
+		}
+		// Either missing or still stored as a string
+		if str, err := d.get(ctx, d.client, keys[i]); err == nil {
+			values[i] = string(str)
+		} else if err != redis.Nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// set queues storing value as the document under key on pipe. The key is
+// deleted first so a value still stored as a string is replaced instead of
+// failing the transaction halfway.
+func (d docStore) set(ctx context.Context, pipe redis.Pipeliner, key string, value []byte) {
+	if !d.json {
+		pipe.Set(ctx, key, value, 0)
+		return
+	}
+	pipe.Del(ctx, key)
+	pipe.Do(ctx, "JSON.SET", key, ".", string(value))
+}
+
+// toDocuments is the migration that converts values stored as strings into
+// RedisJSON documents, and fromDocuments its way back
+func (d docStore) toDocuments(ctx context.Context, tx *redis.Tx, key string) error {
+	if !d.json {
+		return errNoRedisJSON
+	}
+	kind, err := tx.Type(ctx, key).Result()
+	if err != nil || kind != "string" {
+		return err
+	}
+	value, err := tx.Get(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		d.set(ctx, pipe, key, []byte(value))
+		return nil
+	})
+	return err
+}
+
+func (d docStore) fromDocuments(ctx context.Context, tx *redis.Tx, key string) error {
+	kind, err := tx.Type(ctx, key).Result()
+	if err != nil || kind == "string" || kind == "none" {
+		return err
+	}
+	value, err := d.get(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, value, 0)
+		return nil
+	})
+	return err
+}
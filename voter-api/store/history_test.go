@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"voter-api/schema"
+)
+
+// historyLinks is how many different votes are added to the voter's
+// VoteHistory. Each one is added twice at the same time.
+const historyLinks = 200
+
+func TestConcurrentAddToHistoryMemory(t *testing.T) {
+	testConcurrentAddToHistory(t, NewMemoryVoterRepository())
+}
+
+// TestConcurrentAddToHistoryRedis runs against the Redis at REDIS_URL, or
+// the default location, and is skipped if there is none
+func TestConcurrentAddToHistoryRedis(t *testing.T) {
+	location := RedisDefaultLocation
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		location = url
+	}
+	voters, err := NewRedisVoterRepository(location)
+	if err != nil {
+		t.Skip("No Redis at " + location + ": " + err.Error())
+	}
+	testConcurrentAddToHistory(t, voters)
+}
+
+// testConcurrentAddToHistory adds every vote link twice in parallel and
+// checks that each link ends up in the VoteHistory once, with the second
+// add rejected
+func testConcurrentAddToHistory(t *testing.T, voters VoterRepository) {
+	ctx := context.Background()
+	id, err := voters.NextID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := voters.Create(ctx, schema.Voter{VoterID: id, FirstName: "Concurrent", LastName: "Voter"}); err != nil {
+		t.Fatal(err)
+	}
+	defer voters.Delete(ctx, id)
+
+	errs := make([][2]error, historyLinks)
+	var wg sync.WaitGroup
+	for i := 0; i < historyLinks; i++ {
+		for attempt := 0; attempt < 2; attempt++ {
+			wg.Add(1)
+			go func(i int, attempt int) {
+				defer wg.Done()
+				entry := schema.VoteHistoryEntry{
+					PollLink: fmt.Sprintf("/polls/%d", i),
+					VoteLink: fmt.Sprintf("/votes/%d", i),
+				}
+				_, errs[i][attempt] = voters.AddToHistory(ctx, id, entry)
+			}(i, attempt)
+		}
+	}
+	wg.Wait()
+
+	for i, pair := range errs {
+		link := fmt.Sprintf("/votes/%d", i)
+		succeeded, rejected := 0, 0
+		for _, err := range pair {
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, ErrInHistory):
+				rejected++
+			default:
+				t.Errorf("Adding %s: %v", link, err)
+			}
+		}
+		if succeeded != 1 || rejected != 1 {
+			t.Errorf("%s was added %d times and rejected %d times, expected once each", link, succeeded, rejected)
+		}
+	}
+
+	history, err := voters.History(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, entry := range history {
+		if seen[entry.VoteLink] {
+			t.Errorf("%s is in the VoteHistory twice", entry.VoteLink)
+		}
+		seen[entry.VoteLink] = true
+	}
+	for i := 0; i < historyLinks; i++ {
+		if link := fmt.Sprintf("/votes/%d", i); !seen[link] {
+			t.Errorf("%s is missing from the VoteHistory", link)
+		}
+	}
+	if len(history) != historyLinks {
+		t.Errorf("The VoteHistory has %d entries, expected %d", len(history), historyLinks)
+	}
+}
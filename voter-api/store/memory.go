@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"voter-api/schema"
+)
+
+// MemoryVoterRepository keeps voters in process memory. Voters are stored
+// marshalled, like in Redis, so callers never share slices with the store.
+type MemoryVoterRepository struct {
+	mu     sync.Mutex
+	voters map[uint][]byte
+	lastID uint
+}
+
+func NewMemoryVoterRepository() *MemoryVoterRepository {
+	return &MemoryVoterRepository{
+		voters: map[uint][]byte{},
+	}
+}
+
+func (m *MemoryVoterRepository) Get(ctx context.Context, id uint) (schema.Voter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var voterItem schema.Voter
+	value, ok := m.voters[id]
+	if !ok {
+		return voterItem, ErrNotFound
+	}
+	err := json.Unmarshal(value, &voterItem)
+	return voterItem, err
+}
+
+func (m *MemoryVoterRepository) History(ctx context.Context, id uint) ([]schema.VoteHistoryEntry, error) {
+	voterItem, err := m.Get(ctx, id)
+	return voterItem.VoteHistory, err
+}
+
+func (m *MemoryVoterRepository) List(ctx context.Context, filter VoterFilter, page Page) ([]schema.Voter, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	voters := map[uint]schema.Voter{}
+	var items []sortItem
+	for id, value := range m.voters {
+		var voterItem schema.Voter
+		if err := json.Unmarshal(value, &voterItem); err != nil {
+			return nil, "", err
+		}
+		if !filter.matchesName(nameKey(voterItem)) {
+			continue
+		}
+		if filter.VotedIn != "" && !votedIn(voterItem, filter.VotedIn, "") {
+			continue
+		}
+		voters[id] = voterItem
+		item := sortItem{id: id}
+		if page.Sort == SortByName {
+			item.key = nameKey(voterItem)
+		}
+		items = append(items, item)
+	}
+
+	ids, next, err := pageItems(items, page)
+	if err != nil {
+		return nil, "", err
+	}
+	var voterList []schema.Voter
+	for _, id := range ids {
+		voterList = append(voterList, voters[id])
+	}
+	return voterList, next, nil
+}
+
+func (m *MemoryVoterRepository) NextID(ctx context.Context) (uint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID++
+	return m.lastID, nil
+}
+
+func (m *MemoryVoterRepository) Create(ctx context.Context, voter schema.Voter) error {
+	VoterJSON, err := json.Marshal(voter)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.voters[voter.VoterID]; ok {
+		return ErrExists
+	}
+	m.voters[voter.VoterID] = VoterJSON
+	return nil
+}
+
+func (m *MemoryVoterRepository) Update(ctx context.Context, id uint, fn func(*schema.Voter) error) (schema.Voter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var voterItem schema.Voter
+	value, ok := m.voters[id]
+	if !ok {
+		return voterItem, ErrNotFound
+	}
+	if err := json.Unmarshal(value, &voterItem); err != nil {
+		return voterItem, err
+	}
+	if err := fn(&voterItem); err != nil {
+		return voterItem, err
+	}
+
+	VoterJSON, err := json.Marshal(voterItem)
+	if err != nil {
+		return voterItem, err
+	}
+	m.voters[id] = VoterJSON
+	return voterItem, nil
+}
+
+func (m *MemoryVoterRepository) Delete(ctx context.Context, id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.voters[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.voters, id)
+	return nil
+}
+
+func (m *MemoryVoterRepository) AddToHistory(ctx context.Context, id uint, entry schema.VoteHistoryEntry) (schema.Voter, error) {
+	return m.Update(ctx, id, func(voter *schema.Voter) error {
+		if hasEntry(*voter, entry.VoteLink) {
+			return ErrInHistory
+		}
+		voter.VoteHistory = append(voter.VoteHistory, entry)
+		return nil
+	})
+}
+
+func (m *MemoryVoterRepository) RemoveFromHistory(ctx context.Context, id uint, voteLink string) error {
+	_, err := m.Update(ctx, id, func(voter *schema.Voter) error {
+		if _, ok := removeEntry(voter, voteLink); !ok {
+			return ErrNotInHistory
+		}
+		return nil
+	})
+	return err
+}
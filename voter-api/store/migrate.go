@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Migrations change how stored entities look in Redis. Each service keeps an
+// ordered list of them and records in Redis which version its data is at, so
+// "migrate up" only runs the ones not applied yet. A migration rewrites one
+// key at a time and saves the SCAN cursor as it goes, so an interrupted run
+// picks up where it stopped. SCAN may return a key twice, so Up and Down
+// must leave values that already have their target shape alone.
+
+// MigrateFunc rewrites the value under one key, reading and writing through
+// tx, which has the key WATCHed
+type MigrateFunc func(ctx context.Context, tx *redis.Tx, key string) error
+
+// Migration is one step in the evolution of the stored entities. Versions
+// count up from 1 without gaps. Down may be nil if the code before the
+// migration reads the new shape as well.
+type Migration struct {
+	Version     int
+	Description string
+	Up          MigrateFunc
+	Down        MigrateFunc
+}
+
+// Migrator runs migrations over the keys matching a pattern
+type Migrator struct {
+	client     *redis.Client
+	name       string // e.g. "polls", for the version and progress keys
+	pattern    string // e.g. "poll-*"
+	migrations []Migration
+}
+
+func newMigrator(client *redis.Client, name string, pattern string, migrations []Migration) *Migrator {
+	return &Migrator{client: client, name: name, pattern: pattern, migrations: migrations}
+}
+
+// The version key holds the version of the last migration applied. The
+// progress hash holds the version, direction and SCAN cursor of a migration
+// that is running or was interrupted.
+func (m *Migrator) versionKey() string {
+	return "schema-version-" + m.name
+}
+
+func (m *Migrator) progressKey() string {
+	return "migration-progress-" + m.name
+}
+
+// Version returns the version the stored data is at, 0 if no migration was
+// ever applied
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	version, err := m.client.Get(ctx, m.versionKey()).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// Latest is the version of the last known migration
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Migrate runs the migrations up or down until the data is at target
+func (m *Migrator) Migrate(ctx context.Context, target int) error {
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("no version %d of %s (latest is %d)", target, m.name, m.Latest())
+	}
+	current, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	for current < target {
+		if err := m.run(ctx, m.migrations[current], true); err != nil {
+			return err
+		}
+		current++
+	}
+	for current > target {
+		if err := m.run(ctx, m.migrations[current-1], false); err != nil {
+			return err
+		}
+		current--
+	}
+	return nil
+}
+
+// run applies one migration to every key, resuming from the saved cursor if
+// the same migration was interrupted before
+func (m *Migrator) run(ctx context.Context, migration Migration, up bool) error {
+	fn, direction, done := migration.Up, "up", migration.Version
+	if !up {
+		fn, direction, done = migration.Down, "down", migration.Version-1
+	}
+	log.Printf("Migrating %s %s to version %d: %s", m.name, direction, done, migration.Description)
+
+	var cursor uint64
+	progress, err := m.client.HGetAll(ctx, m.progressKey()).Result()
+	if err != nil {
+		return err
+	}
+	if progress["version"] == strconv.Itoa(migration.Version) && progress["direction"] == direction {
+		cursor, _ = strconv.ParseUint(progress["cursor"], 10, 64)
+		log.Printf("Resuming at cursor %d", cursor)
+	}
+
+	for fn != nil {
+		keys, next, err := m.client.Scan(ctx, cursor, m.pattern, 100).Result()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := m.migrateKey(ctx, fn, key); err != nil {
+				return fmt.Errorf("migrating %s: %w", key, err)
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+		err = m.client.HSet(ctx, m.progressKey(),
+			"version", migration.Version, "direction", direction, "cursor", cursor).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = m.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, m.versionKey(), done, 0)
+		pipe.Del(ctx, m.progressKey())
+		return nil
+	})
+	return err
+}
+
+func (m *Migrator) migrateKey(ctx context.Context, fn MigrateFunc, key string) error {
+	txf := func(tx *redis.Tx) error {
+		return fn(ctx, tx, key)
+	}
+	for i := 0; i < maxTxRetries; i++ {
+		err := m.client.Watch(ctx, txf, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("%s changed too often to migrate", key)
+}
+
+// RunMigrateCommand runs "migrate status", "migrate up [version]" (the
+// latest version by default) or "migrate down [version]" (one version down
+// by default)
+func RunMigrateCommand(ctx context.Context, m *Migrator, args []string) error {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || args[0] == "status" {
+		fmt.Printf("%s: at version %d of %d\n", m.name, current, m.Latest())
+		for _, migration := range m.migrations {
+			state := "pending"
+			if migration.Version <= current {
+				state = "applied"
+			}
+			fmt.Printf("  %d %-7s %s\n", migration.Version, state, migration.Description)
+		}
+		return nil
+	}
+
+	target := m.Latest()
+	switch args[0] {
+	case "up":
+	case "down":
+		target = current - 1
+	default:
+		return fmt.Errorf("unknown migrate command %q (expected status, up or down)", args[0])
+	}
+	if len(args) > 1 {
+		target, err = strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+	}
+	if (args[0] == "up" && target < current) || (args[0] == "down" && target > current) {
+		return fmt.Errorf("%s is at version %d, cannot go %s to %d", m.name, current, args[0], target)
+	}
+
+	if err := m.Migrate(ctx, target); err != nil {
+		return err
+	}
+	fmt.Printf("%s: at version %d\n", m.name, target)
+	return nil
+}
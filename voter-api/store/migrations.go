@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+
+	"voter-api/schema"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// voterMigrations are the changes made to stored voters, oldest first. Run
+// them with "voter-api migrate up". describe fills in what votes-api knows
+// about a vote for the entries converted by version 1.
+func voterMigrations(docs docStore, describe func(*schema.VoteHistoryEntry)) []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "turn VoteHistory vote links into structured entries",
+			Up: func(ctx context.Context, tx *redis.Tx, key string) error {
+				return docs.structureVoteHistory(ctx, tx, key, describe)
+			},
+			Down: docs.flattenVoteHistory,
+		},
+		{
+			Version:     2,
+			Description: "store voters as RedisJSON documents",
+			Up:          docs.toDocuments,
+			Down:        docs.fromDocuments,
+		},
+	}
+}
+
+// Migrator runs the voter migrations over this repository's Redis
+func (r *RedisVoterRepository) Migrator(describe func(*schema.VoteHistoryEntry)) *Migrator {
+	return newMigrator(r.client, "voters", "voter-*", voterMigrations(r.docs, describe))
+}
+
+// structureVoteHistory converts a voter whose VoteHistory still holds plain
+// vote links. The poll of each entry comes from the voter's history polls
+// hash, which is dropped afterwards.
+func (docs docStore) structureVoteHistory(ctx context.Context, tx *redis.Tx, key string, describe func(*schema.VoteHistoryEntry)) error {
+	value, err := docs.get(ctx, tx, key)
+	if err == redis.Nil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var voterItem schema.Voter
+	if err := json.Unmarshal(value, &voterItem); err != nil {
+		return err
+	}
+	polls, err := tx.HGetAll(ctx, historyPollsKey(voterItem.VoterID)).Result()
+	if err != nil {
+		return err
+	}
+	if len(polls) == 0 && !hasLegacyHistory(value) {
+		return nil
+	}
+
+	for i := range voterItem.VoteHistory {
+		entry := &voterItem.VoteHistory[i]
+		if entry.PollLink == "" && polls[entry.VoteLink] != "" {
+			entry.PollLink = "/polls/" + polls[entry.VoteLink]
+		}
+		if entry.VoteDate == nil {
+			describe(entry)
+		}
+	}
+
+	VoterJSON, err := json.Marshal(voterItem)
+	if err != nil {
+		return err
+	}
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		docs.set(ctx, pipe, key, VoterJSON)
+		pipe.Del(ctx, historyPollsKey(voterItem.VoterID))
+		for _, entry := range voterItem.VoteHistory {
+			if pollID := entryPollID(entry); pollID != "" {
+				pipe.SAdd(ctx, votedInIndexKey(pollID), voterItem.VoterID)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// flattenVoteHistory turns structured entries back into vote links, keeping
+// their polls in the history polls hash the older code reads them from
+func (docs docStore) flattenVoteHistory(ctx context.Context, tx *redis.Tx, key string) error {
+	value, err := docs.get(ctx, tx, key)
+	if err == redis.Nil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var voterItem schema.Voter
+	if err := json.Unmarshal(value, &voterItem); err != nil {
+		return err
+	}
+
+	flat := struct {
+		VoterID     uint
+		FirstName   string
+		LastName    string
+		VoteHistory []string
+	}{voterItem.VoterID, voterItem.FirstName, voterItem.LastName, []string{}}
+	polls := map[string]interface{}{}
+	for _, entry := range voterItem.VoteHistory {
+		flat.VoteHistory = append(flat.VoteHistory, entry.VoteLink)
+		if pollID := entryPollID(entry); pollID != "" {
+			polls[entry.VoteLink] = pollID
+		}
+	}
+
+	VoterJSON, err := json.Marshal(flat)
+	if err != nil {
+		return err
+	}
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, VoterJSON, 0)
+		if len(polls) > 0 {
+			pipe.HSet(ctx, historyPollsKey(voterItem.VoterID), polls)
+		}
+		return nil
+	})
+	return err
+}
+
+// hasLegacyHistory reports whether a stored voter's VoteHistory holds any
+// plain string entries
+func hasLegacyHistory(value []byte) bool {
+	var raw struct {
+		VoteHistory []json.RawMessage
+	}
+	if err := json.Unmarshal(value, &raw); err != nil {
+		return false
+	}
+	for _, entry := range raw.VoteHistory {
+		if len(entry) > 0 && entry[0] == '"' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// SortByID is the order every listing supports and the default one
+const SortByID = "id"
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page selects part of a listing. Listings are ordered by Sort, ties broken
+// by ID, and reversed if Desc is set. Cursor is empty for the first page and
+// otherwise the cursor returned with the previous page.
+type Page struct {
+	Cursor string
+	Limit  int
+	Sort   string
+	Desc   bool
+}
+
+// byID reports whether the page is ordered by ID alone
+func (p Page) byID() bool {
+	return p.Sort == "" || p.Sort == SortByID
+}
+
+// sortItem is an ID and the key it sorts by. Keys compare as strings, so
+// numbers in them are zero padded.
+type sortItem struct {
+	id  uint
+	key string
+}
+
+func (a sortItem) less(b sortItem) bool {
+	if a.key != b.key {
+		return a.key < b.key
+	}
+	return a.id < b.id
+}
+
+// after decodes the cursor into the item the page starts after, or false
+// for the first page. Cursors of pages ordered by ID are just the last ID
+// seen; other cursors also carry its sort key.
+func (p Page) after() (sortItem, bool, error) {
+	if p.Cursor == "" {
+		return sortItem{}, false, nil
+	}
+
+	cursor, key := p.Cursor, ""
+	if !p.byID() {
+		raw, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+		if err != nil {
+			return sortItem{}, false, ErrInvalidCursor
+		}
+		// The ID comes last, as keys may hold NULs themselves
+		i := strings.LastIndex(string(raw), "\x00")
+		if i < 0 {
+			return sortItem{}, false, ErrInvalidCursor
+		}
+		key, cursor = string(raw[:i]), string(raw[i+1:])
+	}
+
+	id, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return sortItem{}, false, ErrInvalidCursor
+	}
+	return sortItem{id: uint(id), key: key}, true, nil
+}
+
+func (p Page) cursorFor(item sortItem) string {
+	id := strconv.FormatUint(uint64(item.id), 10)
+	if p.byID() {
+		return id
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(item.key + "\x00" + id))
+}
+
+// scoreRange is the ZRANGEBYSCORE range of a page ordered by ID over an
+// index scored by ID
+func (p Page) scoreRange() (string, string, error) {
+	after, ok, err := p.after()
+	if err != nil || !ok {
+		return "-inf", "+inf", err
+	}
+	bound := "(" + strconv.FormatUint(uint64(after.id), 10)
+	if p.Desc {
+		return "-inf", bound, nil
+	}
+	return bound, "+inf", nil
+}
+
+// pageItems sorts the items and picks out the page, returning its IDs and
+// the cursor for the next page, or "" if this is the last one
+func pageItems(items []sortItem, page Page) ([]uint, string, error) {
+	after, ok, err := page.after()
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if page.Desc {
+			return items[j].less(items[i])
+		}
+		return items[i].less(items[j])
+	})
+	start := 0
+	if ok {
+		start = sort.Search(len(items), func(i int) bool {
+			if page.Desc {
+				return items[i].less(after)
+			}
+			return after.less(items[i])
+		})
+	}
+	ids, next := limitItems(items[start:], page)
+	return ids, next, nil
+}
+
+// limitItems cuts items, which may hold more than the page, down to the
+// page and works out the next cursor
+func limitItems(items []sortItem, page Page) ([]uint, string) {
+	next := ""
+	if len(items) > page.Limit {
+		items = items[:page.Limit]
+		next = page.cursorFor(items[len(items)-1])
+	}
+
+	ids := make([]uint, len(items))
+	for i, item := range items {
+		ids[i] = item.id
+	}
+	return ids, next
+}
+
+// idItems wraps IDs that are already in page order
+func idItems(ids []uint) []sortItem {
+	items := make([]sortItem, len(ids))
+	for i, id := range ids {
+		items[i] = sortItem{id: id}
+	}
+	return items
+}
+
+// intersect keeps the IDs present in every set. A nil set stands for "no
+// restriction", so intersect of only nil sets is nil.
+func intersect(sets ...[]uint) []uint {
+	var result []uint
+	restricted := false
+	for _, set := range sets {
+		if set == nil {
+			continue
+		}
+		if !restricted {
+			result, restricted = set, true
+			continue
+		}
+		in := map[uint]bool{}
+		for _, id := range set {
+			in[id] = true
+		}
+		kept := []uint{}
+		for _, id := range result {
+			if in[id] {
+				kept = append(kept, id)
+			}
+		}
+		result = kept
+	}
+	return result
+}
+
+// parseIDs reads the members of an ID index, skipping anything that is not
+// an ID. The result is never nil, so it always restricts an intersect.
+func parseIDs(members []string) []uint {
+	ids := []uint{}
+	for _, member := range members {
+		id, err := strconv.ParseUint(member, 10, 64)
+		if err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
@@ -0,0 +1,509 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"voter-api/schema"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Listing indexes. voterIndexKey is a sorted set of every voter ID, scored
+// by the ID, which lets voters be listed page by page without a KEYS scan.
+// The name index holds "<nameKey>\x00<ID>" for every voter with the same
+// score, so Redis keeps it in name order and ZRANGEBYLEX finds last name
+// prefixes.
+const (
+	voterIndexKey     = "index-voters"
+	voterNameIndexKey = "index-voters-name"
+	nextVoterIDKey    = "next-id-voters" // Counter NextID allocates from
+)
+
+// The set of voters that voted in a poll, for the VotedIn filter
+func votedInIndexKey(pollID string) string {
+	return "index-voters-poll-" + pollID
+}
+
+// A voter's history polls hash mapped each VoteHistory link to the poll it
+// was cast in before entries carried their poll. Migration 1 reads and
+// drops it.
+func historyPollsKey(id uint) string {
+	return fmt.Sprintf("history-polls-voter-%d", id)
+}
+
+func nameIndexMember(voter schema.Voter) string {
+	return nameKey(voter) + "\x00" + strconv.FormatUint(uint64(voter.VoterID), 10)
+}
+
+// addToHistoryScript appends an entry to a voter's VoteHistory unless there
+// is one for the vote link already, and indexes the voter under the entry's
+// poll. Doing both in one step means parallel appends never clash, where a
+// WATCHed transaction would have to retry. It returns the voter, or 0 for a
+// duplicate and -1 if there is no voter.
+//
+// RedisJSON documents are appended to in place. Voters still stored as
+// strings were written by json.Marshal, which puts VoteHistory last, so the
+// entry goes in before the closing "]}" or replaces the "null".
+//
+// KEYS: the voter, the poll's VotedIn index ("" if the entry has no poll)
+// ARGV: the entry as JSON, its vote link, the voter ID
+var addToHistoryScript = redis.NewScript(`
+local kind = redis.call("TYPE", KEYS[1])["ok"]
+if kind == "none" then
+	return -1
+end
+
+local doc
+if kind == "string" then
+	doc = redis.call("GET", KEYS[1])
+else
+	doc = redis.call("JSON.GET", KEYS[1])
+end
+local history = cjson.decode(doc)["VoteHistory"]
+if type(history) == "table" then
+	for _, entry in ipairs(history) do
+		-- Entries written before the migration are plain vote links
+		if entry == ARGV[2] or (type(entry) == "table" and entry["VoteLink"] == ARGV[2]) then
+			return 0
+		end
+	end
+end
+
+if kind ~= "string" then
+	if type(history) == "table" then
+		redis.call("JSON.ARRAPPEND", KEYS[1], ".VoteHistory", ARGV[1])
+	else
+		redis.call("JSON.SET", KEYS[1], ".VoteHistory", "[" .. ARGV[1] .. "]")
+	end
+	doc = redis.call("JSON.GET", KEYS[1])
+elseif string.sub(doc, -5) == "null}" then
+	doc = string.sub(doc, 1, -6) .. "[" .. ARGV[1] .. "]}"
+elseif string.sub(doc, -3) == "[]}" then
+	doc = string.sub(doc, 1, -4) .. "[" .. ARGV[1] .. "]}"
+elseif string.sub(doc, -2) == "]}" then
+	doc = string.sub(doc, 1, -3) .. "," .. ARGV[1] .. "]}"
+else
+	return redis.error_reply("VoteHistory is not the last field of " .. KEYS[1])
+end
+if kind == "string" then
+	redis.call("SET", KEYS[1], doc)
+end
+
+if KEYS[2] ~= "" then
+	redis.call("SADD", KEYS[2], ARGV[3])
+end
+return doc
+`)
+
+type RedisVoterRepository struct {
+	client *redis.Client
+	docs   docStore
+}
+
+func NewRedisVoterRepository(location string) (*RedisVoterRepository, error) {
+	//Connect to redis.  Other options can be provided, but the defaults are OK
+	client := redis.NewClient(&redis.Options{
+		Addr: location,
+	})
+
+	ctx := context.Background()
+
+	//Ensure that our redis connection is working
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		log.Println("Error connecting to redis" + err.Error())
+		return nil, err
+	}
+
+	r := &RedisVoterRepository{
+		client: client,
+		docs:   newDocStore(ctx, client),
+	}
+	if err := r.buildIndex(ctx); err != nil {
+		log.Println("Error building voter index: " + err.Error())
+		return nil, err
+	}
+	return r, nil
+}
+
+// buildIndex indexes voters stored before the indexes existed. It only runs
+// while an index is missing and uses SCAN, so it never blocks Redis.
+func (r *RedisVoterRepository) buildIndex(ctx context.Context) error {
+	indexed, err := r.client.Exists(ctx, voterIndexKey, voterNameIndexKey).Result()
+	if err != nil || indexed == 2 {
+		return err
+	}
+
+	iter := r.client.Scan(ctx, 0, "voter-*", 1000).Iterator()
+	for iter.Next(ctx) {
+		value, err := r.docs.get(ctx, r.client, iter.Val())
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return err
+		}
+		var voterItem schema.Voter
+		if err := json.Unmarshal(value, &voterItem); err != nil {
+			log.Printf("Not indexing %s: %v", iter.Val(), err)
+			continue
+		}
+
+		_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			indexVoter(ctx, pipe, nil, voterItem)
+			for _, entry := range voterItem.VoteHistory {
+				if pollID := entryPollID(entry); pollID != "" {
+					pipe.SAdd(ctx, votedInIndexKey(pollID), voterItem.VoterID)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func voterKey(id uint) string {
+	return fmt.Sprintf("voter-%d", id)
+}
+
+func (r *RedisVoterRepository) Get(ctx context.Context, id uint) (schema.Voter, error) {
+	var voterItem schema.Voter
+
+	value, err := r.docs.get(ctx, r.client, voterKey(id))
+	if err == redis.Nil {
+		return voterItem, ErrNotFound
+	} else if err != nil {
+		return voterItem, err
+	}
+
+	err = json.Unmarshal(value, &voterItem)
+	return voterItem, err
+}
+
+// History reads only the voter's VoteHistory when the voter is stored as a
+// RedisJSON document
+func (r *RedisVoterRepository) History(ctx context.Context, id uint) ([]schema.VoteHistoryEntry, error) {
+	value, ok, err := r.docs.getPath(ctx, voterKey(id), ".VoteHistory")
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if !ok {
+		voterItem, err := r.Get(ctx, id)
+		return voterItem.VoteHistory, err
+	}
+
+	var history []schema.VoteHistoryEntry
+	err = json.Unmarshal(value, &history)
+	return history, err
+}
+
+func (r *RedisVoterRepository) List(ctx context.Context, filter VoterFilter, page Page) ([]schema.Voter, string, error) {
+	var ids []uint
+	var next string
+	var err error
+	if filter == (VoterFilter{}) && page.byID() {
+		ids, next, err = r.pageByID(ctx, page)
+	} else {
+		ids, next, err = r.query(ctx, filter, page)
+	}
+	if err != nil || len(ids) == 0 {
+		return nil, "", err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = voterKey(id)
+	}
+	values, err := r.docs.mget(ctx, keys...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var voterList []schema.Voter
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			// Indexed but gone, e.g. deleted while we were reading
+			continue
+		}
+		var voterItem schema.Voter
+		if err := json.Unmarshal([]byte(str), &voterItem); err != nil {
+			return nil, "", fmt.Errorf("could not read %s: %w", keys[i], err)
+		}
+		voterList = append(voterList, voterItem)
+	}
+	return voterList, next, nil
+}
+
+// pageByID reads an unfiltered page in ID order straight from the ID index
+func (r *RedisVoterRepository) pageByID(ctx context.Context, page Page) ([]uint, string, error) {
+	min, max, err := page.scoreRange()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Ask the index for one voter more than the page to know if there is a
+	// next page
+	by := &redis.ZRangeBy{Min: min, Max: max, Count: int64(page.Limit) + 1}
+	var members []string
+	if page.Desc {
+		members, err = r.client.ZRevRangeByScore(ctx, voterIndexKey, by).Result()
+	} else {
+		members, err = r.client.ZRangeByScore(ctx, voterIndexKey, by).Result()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids, next := limitItems(idItems(parseIDs(members)), page)
+	return ids, next, nil
+}
+
+// query works out a filtered or sorted page from the secondary indexes
+// alone, so only the voters on the page are read
+func (r *RedisVoterRepository) query(ctx context.Context, filter VoterFilter, page Page) ([]uint, string, error) {
+	var nameIDs, votedIDs []uint
+	names := map[uint]string{}
+	if filter.LastName != "" || filter.FirstName != "" || page.Sort == SortByName {
+		by := &redis.ZRangeBy{Min: "-", Max: "+"}
+		if filter.LastName != "" {
+			prefix := strings.ToLower(filter.LastName)
+			by = &redis.ZRangeBy{Min: "[" + prefix, Max: "[" + prefix + "\xff"}
+		}
+		members, err := r.client.ZRangeByLex(ctx, voterNameIndexKey, by).Result()
+		if err != nil {
+			return nil, "", err
+		}
+
+		nameIDs = []uint{}
+		for _, member := range members {
+			i := strings.LastIndex(member, "\x00")
+			id, err := strconv.ParseUint(member[i+1:], 10, 64)
+			if i < 0 || err != nil || !filter.matchesName(member[:i]) {
+				continue
+			}
+			names[uint(id)] = member[:i]
+			nameIDs = append(nameIDs, uint(id))
+		}
+		if filter.LastName == "" && filter.FirstName == "" {
+			nameIDs = nil
+		}
+	}
+
+	if filter.VotedIn != "" {
+		members, err := r.client.SMembers(ctx, votedInIndexKey(filter.VotedIn)).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		votedIDs = parseIDs(members)
+	}
+
+	ids := intersect(nameIDs, votedIDs)
+	if ids == nil {
+		members, err := r.client.ZRange(ctx, voterIndexKey, 0, -1).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		ids = parseIDs(members)
+	}
+
+	items := idItems(ids)
+	if page.Sort == SortByName {
+		for i := range items {
+			items[i].key = names[items[i].id]
+		}
+	}
+	return pageItems(items, page)
+}
+
+func (r *RedisVoterRepository) NextID(ctx context.Context) (uint, error) {
+	id, err := r.client.Incr(ctx, nextVoterIDKey).Result()
+	return uint(id), err
+}
+
+func (r *RedisVoterRepository) Create(ctx context.Context, voter schema.Voter) error {
+	VoterJSON, err := json.Marshal(voter)
+	if err != nil {
+		return err
+	}
+	key := voterKey(voter.VoterID)
+
+	// WATCH the key so the uniqueness check, the write and the indexing are
+	// a single step
+	txf := func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return ErrExists
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.docs.set(ctx, pipe, key, VoterJSON)
+			indexVoter(ctx, pipe, nil, voter)
+			return nil
+		})
+		return err
+	}
+	return r.watch(ctx, voter.VoterID, txf)
+}
+
+func (r *RedisVoterRepository) Update(ctx context.Context, id uint, fn func(*schema.Voter) error) (schema.Voter, error) {
+	var voterItem schema.Voter
+
+	// WATCH the voter so the write fails, and is retried, if another writer
+	// changes the voter in the meantime
+	txf := func(tx *redis.Tx) error {
+		old, err := r.getVoter(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		voterItem = old
+		if err := fn(&voterItem); err != nil {
+			return err
+		}
+
+		VoterJSON, err := json.Marshal(voterItem)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.docs.set(ctx, pipe, voterKey(id), VoterJSON)
+			indexVoter(ctx, pipe, &old, voterItem)
+			return nil
+		})
+		return err
+	}
+	return voterItem, r.watch(ctx, id, txf)
+}
+
+func (r *RedisVoterRepository) Delete(ctx context.Context, id uint) error {
+	txf := func(tx *redis.Tx) error {
+		voterItem, err := r.getVoter(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, voterKey(id), historyPollsKey(id))
+			pipe.ZRem(ctx, voterIndexKey, id)
+			pipe.ZRem(ctx, voterNameIndexKey, nameIndexMember(voterItem))
+			for _, entry := range voterItem.VoteHistory {
+				if pollID := entryPollID(entry); pollID != "" {
+					pipe.SRem(ctx, votedInIndexKey(pollID), id)
+				}
+			}
+			return nil
+		})
+		return err
+	}
+	return r.watch(ctx, id, txf)
+}
+
+func (r *RedisVoterRepository) AddToHistory(ctx context.Context, id uint, entry schema.VoteHistoryEntry) (schema.Voter, error) {
+	var voterItem schema.Voter
+
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return voterItem, err
+	}
+	index := ""
+	if pollID := entryPollID(entry); pollID != "" {
+		index = votedInIndexKey(pollID)
+	}
+
+	keys := []string{voterKey(id), index}
+	res, err := addToHistoryScript.Run(ctx, r.client, keys, entryJSON, entry.VoteLink, id).Result()
+	if err != nil {
+		return voterItem, err
+	}
+	switch res := res.(type) {
+	case int64:
+		if res < 0 {
+			return voterItem, ErrNotFound
+		}
+		return voterItem, ErrInHistory
+	case string:
+		err = json.Unmarshal([]byte(res), &voterItem)
+		return voterItem, err
+	}
+	return voterItem, fmt.Errorf("unexpected reply %v adding to %s", res, voterKey(id))
+}
+
+func (r *RedisVoterRepository) RemoveFromHistory(ctx context.Context, id uint, voteLink string) error {
+	txf := func(tx *redis.Tx) error {
+		voterItem, err := r.getVoter(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		removed, ok := removeEntry(&voterItem, voteLink)
+		if !ok {
+			return ErrNotInHistory
+		}
+
+		VoterJSON, err := json.Marshal(voterItem)
+		if err != nil {
+			return err
+		}
+
+		// The voter stays in the poll's VotedIn set while another entry
+		// was cast in the same poll
+		pollID := entryPollID(removed)
+		stillVoted := votedIn(voterItem, pollID, voteLink)
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.docs.set(ctx, pipe, voterKey(id), VoterJSON)
+			if pollID != "" && !stillVoted {
+				pipe.SRem(ctx, votedInIndexKey(pollID), id)
+			}
+			return nil
+		})
+		return err
+	}
+	return r.watch(ctx, id, txf)
+}
+
+// watch runs txf with the voter's keys WATCHed, retrying it while another
+// writer changes them in the meantime
+func (r *RedisVoterRepository) watch(ctx context.Context, id uint, txf func(*redis.Tx) error) error {
+	for i := 0; i < maxTxRetries; i++ {
+		err := r.client.Watch(ctx, txf, voterKey(id), historyPollsKey(id))
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("voter %s changed too often to update", voterKey(id))
+}
+
+func (r *RedisVoterRepository) getVoter(ctx context.Context, tx *redis.Tx, id uint) (schema.Voter, error) {
+	var voterItem schema.Voter
+	value, err := r.docs.get(ctx, tx, voterKey(id))
+	if err == redis.Nil {
+		return voterItem, ErrNotFound
+	} else if err != nil {
+		return voterItem, err
+	}
+	err = json.Unmarshal(value, &voterItem)
+	return voterItem, err
+}
+
+// indexVoter records the voter in the listing indexes, replacing its old
+// name if that changed
+func indexVoter(ctx context.Context, pipe redis.Pipeliner, old *schema.Voter, voter schema.Voter) {
+	if old != nil && nameIndexMember(*old) != nameIndexMember(voter) {
+		pipe.ZRem(ctx, voterNameIndexKey, nameIndexMember(*old))
+	}
+	pipe.ZAdd(ctx, voterIndexKey, &redis.Z{Score: float64(voter.VoterID), Member: voter.VoterID})
+	pipe.ZAdd(ctx, voterNameIndexKey, &redis.Z{Score: 0, Member: nameIndexMember(voter)})
+}
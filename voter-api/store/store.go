@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"voter-api/schema"
+)
+
+const (
+	RedisNilError        = "redis: nil"
+	RedisDefaultLocation = "0.0.0.0:6379"
+
+	maxTxRetries = 10
+)
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrExists       = errors.New("already exists")
+	ErrNotInHistory = errors.New("not in the voter's VoteHistory")
+	ErrInHistory    = errors.New("already in the voter's VoteHistory")
+)
+
+// SortByName orders the voter listing by LastName, then FirstName, ignoring
+// case
+const SortByName = "name"
+
+// VoterFilter narrows the voter listing. Zero fields match every voter.
+type VoterFilter struct {
+	LastName  string // Case-insensitive prefix of LastName
+	FirstName string // Case-insensitive prefix of FirstName
+	VotedIn   string // ID of a poll the voter has voted in
+}
+
+// matchesName checks the name filters against a voter's nameKey
+func (f VoterFilter) matchesName(key string) bool {
+	last, first, _ := strings.Cut(key, "\x00")
+	return strings.HasPrefix(last, strings.ToLower(f.LastName)) &&
+		strings.HasPrefix(first, strings.ToLower(f.FirstName))
+}
+
+// nameKey is what voters are searched and sorted by name on
+func nameKey(voter schema.Voter) string {
+	return strings.ToLower(voter.LastName) + "\x00" + strings.ToLower(voter.FirstName)
+}
+
+// entryPollID is the ID of the poll a VoteHistory entry was cast in, or ""
+// if it is not known
+func entryPollID(entry schema.VoteHistoryEntry) string {
+	return strings.TrimPrefix(entry.PollLink, "/polls/")
+}
+
+// votedIn reports whether the voter has a VoteHistory entry cast in the
+// poll, ignoring the entry for skipLink
+func votedIn(voter schema.Voter, pollID string, skipLink string) bool {
+	for _, entry := range voter.VoteHistory {
+		if entry.VoteLink != skipLink && entryPollID(entry) == pollID {
+			return true
+		}
+	}
+	return false
+}
+
+// hasEntry reports whether the voter's VoteHistory has an entry for voteLink
+func hasEntry(voter schema.Voter, voteLink string) bool {
+	for _, entry := range voter.VoteHistory {
+		if entry.VoteLink == voteLink {
+			return true
+		}
+	}
+	return false
+}
+
+// removeEntry takes the entry for voteLink out of the voter's VoteHistory,
+// returning it, or false if there is none
+func removeEntry(voter *schema.Voter, voteLink string) (schema.VoteHistoryEntry, bool) {
+	var removed schema.VoteHistoryEntry
+	found := false
+	history := []schema.VoteHistoryEntry{}
+	for _, entry := range voter.VoteHistory {
+		if entry.VoteLink == voteLink {
+			removed, found = entry, true
+			continue
+		}
+		history = append(history, entry)
+	}
+	voter.VoteHistory = history
+	return removed, found
+}
+
+// VoterRepository stores voters. There is a Redis implementation for
+// production and an in-memory one for running the service without Redis.
+type VoterRepository interface {
+	Get(ctx context.Context, id uint) (schema.Voter, error)
+	// History returns just the voter's VoteHistory
+	History(ctx context.Context, id uint) ([]schema.VoteHistoryEntry, error)
+	// List returns a page of the voters matching the filter and the cursor
+	// for the next page ("" after the last page)
+	List(ctx context.Context, filter VoterFilter, page Page) ([]schema.Voter, string, error)
+
+	// NextID allocates an ID for a new voter. IDs are never handed out
+	// twice, but a client may have picked one already, so Create can still
+	// return ErrExists.
+	NextID(ctx context.Context) (uint, error)
+	// Create stores a new voter, or returns ErrExists if the ID is taken
+	Create(ctx context.Context, voter schema.Voter) error
+
+	// Update applies fn to the stored voter atomically, so concurrent
+	// updates are never lost. An error from fn aborts the update and is
+	// returned as is.
+	Update(ctx context.Context, id uint, fn func(*schema.Voter) error) (schema.Voter, error)
+	Delete(ctx context.Context, id uint) error
+
+	// AddToHistory appends an entry to the voter's VoteHistory and indexes
+	// the voter under the entry's poll for the VotedIn filter. It returns
+	// ErrInHistory if there is an entry for the vote link already.
+	// RemoveFromHistory takes the entry for the vote link out again,
+	// returning ErrNotInHistory if there is none.
+	AddToHistory(ctx context.Context, id uint, entry schema.VoteHistoryEntry) (schema.Voter, error)
+	RemoveFromHistory(ctx context.Context, id uint, voteLink string) error
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"votes-api/schema"
+
+	"github.com/gin-gonic/gin"
+)
+
+// invalidBallotError explains which field of a vote does not fit its poll
+type invalidBallotError struct {
+	Field   string
+	Message string
+}
+
+func (e *invalidBallotError) Error() string {
+	return e.Message
+}
+
+// ballotProblem is the answer to a vote that does not fit its poll. It
+// lists the poll's options so the client can fix the vote.
+type ballotProblem struct {
+	Problem
+	ValidOptions []schema.PollOption `json:"validOptions"`
+}
+
+func invalidBallot(c *gin.Context, err *invalidBallotError, poll schema.Poll) {
+	problem := newProblem(c, http.StatusBadRequest, codeValidationFailed, err.Message)
+	problem.Errors = []FieldError{{Field: err.Field, Reason: err.Message}}
+	sendProblem(c, http.StatusBadRequest, ballotProblem{problem, poll.PollOptions})
+}
+
+// validateBallot checks the vote against the poll's type and options. For
+// ranked polls VoteValue is filled in with the first preference.
+func validateBallot(poll schema.Poll, vote *schema.Vote) *invalidBallotError {
+	if poll.PollType != schema.PollTypeRanked && len(vote.VoteRanking) != 0 {
+		return &invalidBallotError{"VoteRanking", "VoteRanking is only accepted by ranked polls."}
+	}
+	isSelection := poll.PollType == schema.PollTypeApproval || poll.PollType == schema.PollTypeMulti
+	if !isSelection && len(vote.VoteSelections) != 0 {
+		return &invalidBallotError{"VoteSelections", "VoteSelections is only accepted by approval and multi-select polls."}
+	}
+	if poll.PollType != schema.PollTypeScore && len(vote.VoteScores) != 0 {
+		return &invalidBallotError{"VoteScores", "VoteScores is only accepted by score polls."}
+	}
+
+	switch {
+	case poll.PollType == schema.PollTypeRanked:
+		if len(vote.VoteRanking) == 0 {
+			return &invalidBallotError{"VoteRanking", "Ranked polls need at least one preference in VoteRanking."}
+		}
+		if ballotErr := checkOptions(poll, "VoteRanking", vote.VoteRanking); ballotErr != nil {
+			return ballotErr
+		}
+		vote.VoteValue = vote.VoteRanking[0]
+
+	case isSelection:
+		min, max := poll.SelectionLimits()
+		count := uint(len(vote.VoteSelections))
+		if count < min || count > max {
+			return &invalidBallotError{"VoteSelections", fmt.Sprintf("Select between %d and %d options (got %d).", min, max, count)}
+		}
+		if ballotErr := checkOptions(poll, "VoteSelections", vote.VoteSelections); ballotErr != nil {
+			return ballotErr
+		}
+
+	case poll.PollType == schema.PollTypeScore:
+		if len(vote.VoteScores) == 0 {
+			return &invalidBallotError{"VoteScores", "Score polls need at least one rating in VoteScores."}
+		}
+		var optionIDs []uint
+		min, max := poll.ScoreRange()
+		for _, rating := range vote.VoteScores {
+			if rating.Score < min || rating.Score > max {
+				return &invalidBallotError{"VoteScores", fmt.Sprintf("Option %d is scored %d, scores must be between %d and %d.", rating.PollOptionID, rating.Score, min, max)}
+			}
+			optionIDs = append(optionIDs, rating.PollOptionID)
+		}
+		if ballotErr := checkOptions(poll, "VoteScores", optionIDs); ballotErr != nil {
+			return ballotErr
+		}
+
+	default:
+		if !poll.HasOption(vote.VoteValue) {
+			return &invalidBallotError{"VoteValue", fmt.Sprintf("Option %d is not a valid option for poll %d.", vote.VoteValue, poll.PollID)}
+		}
+	}
+	return nil
+}
+
+// checkOptions makes sure every option ID belongs to the poll and appears
+// only once
+func checkOptions(poll schema.Poll, field string, optionIDs []uint) *invalidBallotError {
+	seen := map[uint]bool{}
+	for _, optionID := range optionIDs {
+		if !poll.HasOption(optionID) {
+			return &invalidBallotError{field, fmt.Sprintf("Option %d is not a valid option for poll %d.", optionID, poll.PollID)}
+		}
+		if seen[optionID] {
+			return &invalidBallotError{field, fmt.Sprintf("Option %d is listed more than once.", optionID)}
+		}
+		seen[optionID] = true
+	}
+	return nil
+}
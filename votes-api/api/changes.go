@@ -0,0 +1,220 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"votes-api/schema"
+	"votes-api/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A vote may be changed with PUT /votes/:id or retracted with DELETE
+// /votes/:id while its poll is open and for the poll's VoteChangeSeconds
+// after it was cast. The window is checked again inside the store's
+// transaction, so a vote is never changed after it closed. Every change
+// keeps the vote as it was in the vote's audit trail.
+
+// errVoteFinal is returned when a vote can no longer be changed or retracted
+var errVoteFinal = errors.New("vote is final")
+
+// checkVoteChangeable returns errVoteFinal, explaining why, if the vote in
+// the poll cannot be changed or retracted at now
+func checkVoteChangeable(vote schema.Vote, poll schema.Poll, now time.Time) error {
+	switch {
+	case vote.VoterID == "":
+		return fmt.Errorf("%w: the vote was anonymized", errVoteFinal)
+	case !poll.AcceptsVotesAt(now):
+		return fmt.Errorf("%w: the poll is not open for voting (status: %s)", errVoteFinal, poll.PollStatus)
+	case poll.VoteChangeWindow() == 0 || vote.CastAt == nil:
+		return fmt.Errorf("%w: the poll does not allow changing votes", errVoteFinal)
+	case now.After(vote.CastAt.Add(poll.VoteChangeWindow())):
+		return fmt.Errorf("%w: votes may only be changed for %s after they are cast", errVoteFinal, poll.VoteChangeWindow())
+	}
+	return nil
+}
+
+// pollForChange fetches the poll of a vote that is about to change,
+// responding with an error if it cannot be read
+func (p *VotesAPI) pollForChange(c *gin.Context, vote schema.Vote) (schema.Poll, bool) {
+	poll, err := p.pollAPI.Get(c.Request.Context(), vote.PollID)
+	if err == errPollNotFound {
+		conflict(c, "The vote's poll no longer exists.")
+		return poll, false
+	} else if err != nil {
+		log.Printf("Error getting poll %s: %v", vote.PollID, err)
+		dependencyError(c, err, "Failed to get the vote's poll")
+		return poll, false
+	}
+	return poll, true
+}
+
+// sameLink reports whether a PollID or VoterID sent by a client, either an
+// ID like "1" or a link like "/polls/1", is empty or points where link does
+func sameLink(given string, link string) bool {
+	if given == "" {
+		return true
+	}
+	prefix := link[:strings.LastIndex(link, "/")+1]
+	id, ok := canonicalID(given, prefix)
+	return ok && prefix+id == link
+}
+
+// PutVote replaces the ballot of the vote in the :id param. The vote stays
+// in the same poll, by the same voter and keeps its CastAt.
+func (p *VotesAPI) PutVote(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var newVote schema.Vote
+	if !bindJSON(c, &newVote) {
+		return
+	}
+
+	current, err := p.votes.Get(c, id)
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Vote %d does not exist", id))
+		return
+	} else if err != nil {
+		log.Printf("Error getting vote %d: %v", id, err)
+		internalError(c, "Error getting vote")
+		return
+	}
+
+	if newVote.VoteID != 0 && newVote.VoteID != id {
+		badRequest(c, "VoteID cannot be changed")
+		return
+	}
+	if !sameLink(newVote.PollID, current.PollID) || !sameLink(newVote.VoterID, current.VoterID) {
+		badRequest(c, "A vote cannot move to another poll or voter")
+		return
+	}
+
+	poll, ok := p.pollForChange(c, current)
+	if !ok {
+		return
+	}
+	if err := checkVoteChangeable(current, poll, time.Now()); err != nil {
+		conflict(c, err.Error())
+		return
+	}
+
+	if ballotErr := validateBallot(poll, &newVote); ballotErr != nil {
+		invalidBallot(c, ballotErr, poll)
+		return
+	}
+
+	updated, err := p.votes.Update(c, id, func(vote *schema.Vote) error {
+		if err := checkVoteChangeable(*vote, poll, time.Now()); err != nil {
+			return err
+		}
+		newVote.VoteID, newVote.PollID, newVote.VoterID, newVote.CastAt = vote.VoteID, vote.PollID, vote.VoterID, vote.CastAt
+		*vote = newVote
+		return nil
+	})
+	if errors.Is(err, errVoteFinal) {
+		conflict(c, err.Error())
+		return
+	} else if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Vote %d does not exist", id))
+		return
+	} else if err != nil {
+		log.Printf("Error changing vote %d: %v", id, err)
+		internalError(c, "Failed to change the vote")
+		return
+	}
+
+	// The voter's VoteHistory entry lists the options, so it changes too.
+	// If that fails the reconciler brings the entry up to date.
+	if err := p.voterAPI.UpdateHistory(c.Request.Context(), updated.VoterID, historyEntry(updated)); err != nil {
+		log.Printf("Failed to update %s in %s, leaving it to the reconciler: %v", historyEntry(updated).VoteLink, updated.VoterID, err)
+		p.queueHistoryFix(c, updated.VoterID, updated.VoteID)
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteVote retracts the vote in the :id param, giving the voter their
+// ballot back. The vote is taken out of the voter's VoteHistory afterwards;
+// if that fails the reconciler removes the link, as it points at a vote
+// that no longer exists.
+func (p *VotesAPI) DeleteVote(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	current, err := p.votes.Get(c, id)
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Vote %d does not exist", id))
+		return
+	} else if err != nil {
+		log.Printf("Error getting vote %d: %v", id, err)
+		internalError(c, "Error getting vote")
+		return
+	}
+
+	poll, ok := p.pollForChange(c, current)
+	if !ok {
+		return
+	}
+
+	retracted, err := p.votes.Delete(c, id, func(vote schema.Vote) error {
+		return checkVoteChangeable(vote, poll, time.Now())
+	})
+	if errors.Is(err, errVoteFinal) {
+		conflict(c, err.Error())
+		return
+	} else if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Vote %d does not exist", id))
+		return
+	} else if err != nil {
+		log.Printf("Error retracting vote %d: %v", id, err)
+		internalError(c, "Failed to retract the vote")
+		return
+	}
+
+	link := fmt.Sprintf("/votes/%d", id)
+	if err := p.voterAPI.RemoveFromHistory(c.Request.Context(), retracted.VoterID, link); err != nil {
+		log.Printf("Failed to remove %s from %s, leaving it to the reconciler: %v", link, retracted.VoterID, err)
+		p.queueHistoryFix(c, retracted.VoterID, id)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Vote %d retracted", id)})
+}
+
+// GetVoteAudit lists the changes made to the vote in the :id param, each
+// with the vote as it was before. A retracted vote keeps its trail; an ID
+// with neither a trail nor a vote is not found.
+func (p *VotesAPI) GetVoteAudit(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	trail, err := p.votes.AuditTrail(c, id)
+	if err != nil {
+		log.Printf("Error getting the audit trail of vote %d: %v", id, err)
+		internalError(c, "Error getting the vote's audit trail")
+		return
+	}
+	if len(trail) == 0 {
+		if _, err := p.votes.Get(c, id); err == store.ErrNotFound {
+			notFound(c, fmt.Sprintf("Vote %d does not exist", id))
+			return
+		} else if err != nil {
+			log.Printf("Error getting vote %d: %v", id, err)
+			internalError(c, "Error getting vote")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, trail)
+}
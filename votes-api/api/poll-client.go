@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"votes-api/schema"
+)
+
+var (
+	errPollNotFound = fmt.Errorf("poll not found")
+	// errPollChanged means the poll stopped accepting a vote while it was
+	// being cast
+	errPollChanged = fmt.Errorf("poll changed")
+)
+
+// pollClient reads polls from poll-api
+type pollClient struct {
+	*serviceClient
+}
+
+func newPollClient(baseURL string) *pollClient {
+	return &pollClient{newServiceClient("poll-api", baseURL)}
+}
+
+// Get fetches a poll by its link, e.g. "/polls/1"
+func (p *pollClient) Get(ctx context.Context, pollLink string) (schema.Poll, error) {
+	var poll schema.Poll
+
+	resp, err := p.do(ctx, http.MethodGet, pollLink, nil)
+	if err != nil {
+		return poll, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return poll, resp.decode(&poll)
+	case http.StatusNotFound:
+		return poll, errPollNotFound
+	}
+	return poll, p.unexpected(resp, "reading poll "+pollLink)
+}
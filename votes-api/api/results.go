@@ -0,0 +1,154 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"votes-api/schema"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CountPollVotes counts the votes in the poll in the :id param. poll-api
+// asks before it deletes a poll or changes its options.
+func (p *VotesAPI) CountPollVotes(c *gin.Context) {
+	pollID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	id := strconv.FormatUint(uint64(pollID), 10)
+
+	tally, err := p.votes.Tally(c, id)
+	if err != nil {
+		internalError(c, "Failed to read results from cache")
+		return
+	}
+	pending, err := p.pollPending(c, id)
+	if err != nil {
+		internalError(c, "Failed to read pending votes from cache")
+		return
+	}
+	c.JSON(http.StatusOK, schema.PollVoteCount{PollID: "/polls/" + id, Votes: tally.Ballots, Pending: len(pending)})
+}
+
+func (p *VotesAPI) GetPollResults(c *gin.Context) {
+	pollID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	id := strconv.FormatUint(uint64(pollID), 10)
+
+	tally, err := p.votes.Tally(c, id)
+	if err != nil {
+		internalError(c, "Failed to read results from cache")
+		return
+	}
+
+	results := schema.PollResults{PollID: "/polls/" + id}
+	byOption := map[uint]*schema.OptionResult{}
+
+	// Start from the poll's options so options nobody voted for are listed
+	// too. Without them the results would be incomplete, so they are not
+	// served if poll-api cannot be reached.
+	poll, err := p.pollAPI.Get(c.Request.Context(), "/polls/"+id)
+	if err == errPollNotFound && len(tally.Options) == 0 {
+		notFound(c, "The poll doesn't exist.")
+		return
+	} else if err != nil && err != errPollNotFound {
+		dependencyError(c, err, "Could not fetch poll "+id+" for its results")
+		return
+	}
+	for _, option := range poll.PollOptions {
+		byOption[option.PollOptionID] = &schema.OptionResult{
+			PollOptionID:   option.PollOptionID,
+			PollOptionText: option.PollOptionText,
+		}
+	}
+
+	var optionVotes int64
+	for optionID, votes := range tally.Options {
+		option, ok := byOption[optionID]
+		if !ok {
+			option = &schema.OptionResult{PollOptionID: optionID}
+			byOption[optionID] = option
+		}
+		option.Votes = votes
+		optionVotes += votes
+	}
+
+	// Selection and score votes add to several options, so those polls use
+	// the ballot counter and their percentages can add up to more than 100%.
+	// For every other poll the option counts add up to the votes cast.
+	results.TotalVotes = optionVotes
+	switch poll.PollType {
+	case schema.PollTypeApproval, schema.PollTypeMulti, schema.PollTypeScore:
+		results.TotalVotes = tally.Ballots
+	}
+
+	if poll.PollType == schema.PollTypeScore {
+		min, max := poll.ScoreRange()
+		for optionID, option := range byOption {
+			summary := summarizeScores(tally.Scores[optionID], min, max)
+			option.Scores = &summary
+		}
+	}
+
+	for _, option := range byOption {
+		if results.TotalVotes > 0 {
+			option.Percentage = math.Round(float64(option.Votes)/float64(results.TotalVotes)*10000) / 100
+		}
+		results.Options = append(results.Options, *option)
+	}
+	sort.Slice(results.Options, func(i, j int) bool {
+		return results.Options[i].PollOptionID < results.Options[j].PollOptionID
+	})
+
+	if poll.PollType == schema.PollTypeRanked {
+		var candidates []schema.PollOption
+		for _, option := range results.Options {
+			candidates = append(candidates, schema.PollOption{
+				PollOptionID:   option.PollOptionID,
+				PollOptionText: option.PollOptionText,
+			})
+		}
+		results.Rounds, results.Winner = instantRunoff(candidates, tally.Rankings)
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// summarizeScores works out the mean and median from how often each score
+// in [min, max] was given
+func summarizeScores(distribution map[uint]int64, min uint, max uint) schema.ScoreSummary {
+	var summary schema.ScoreSummary
+	var ratings, sum int64
+	for score := min; score <= max; score++ {
+		count := distribution[score]
+		summary.Distribution = append(summary.Distribution, schema.ScoreCount{Score: score, Count: count})
+		ratings += count
+		sum += int64(score) * count
+	}
+	if ratings == 0 {
+		return summary
+	}
+	summary.Mean = math.Round(float64(sum)/float64(ratings)*100) / 100
+
+	// The median is the middle rating, or the average of the two middle
+	// ratings when there is an even number of them
+	lowMiddle, highMiddle := (ratings-1)/2, ratings/2
+	var seen int64
+	var low, high uint
+	for _, bucket := range summary.Distribution {
+		if seen <= lowMiddle && lowMiddle < seen+bucket.Count {
+			low = bucket.Score
+		}
+		if seen <= highMiddle && highMiddle < seen+bucket.Count {
+			high = bucket.Score
+		}
+		seen += bucket.Count
+	}
+	summary.Median = float64(low+high) / 2
+	return summary
+}
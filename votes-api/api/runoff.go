@@ -0,0 +1,99 @@
+package api
+
+import (
+	"math"
+	"sort"
+
+	"votes-api/schema"
+)
+
+// instantRunoff counts ranked ballots round by round. Each ballot counts
+// for its highest-ranked option still in the race; an option with more than
+// half of the active ballots wins, otherwise the option with the fewest
+// votes is eliminated. Ties for last place are broken by eliminating the
+// option with fewer first preferences, then the higher PollOptionID.
+func instantRunoff(options []schema.PollOption, ballots [][]uint) ([]schema.RunoffRound, *uint) {
+	texts := map[uint]string{}
+	remaining := map[uint]bool{}
+	for _, option := range options {
+		texts[option.PollOptionID] = option.PollOptionText
+		remaining[option.PollOptionID] = true
+	}
+
+	var rounds []schema.RunoffRound
+	var firstRound map[uint]int64
+
+	for round := 1; len(remaining) > 0; round++ {
+		counts := map[uint]int64{}
+		for optionID := range remaining {
+			counts[optionID] = 0
+		}
+
+		var active, exhausted int64
+		for _, ballot := range ballots {
+			counted := false
+			for _, optionID := range ballot {
+				if remaining[optionID] {
+					counts[optionID]++
+					counted = true
+					break
+				}
+			}
+			if counted {
+				active++
+			} else {
+				exhausted++
+			}
+		}
+		if firstRound == nil {
+			firstRound = counts
+		}
+
+		current := schema.RunoffRound{Round: round, Exhausted: exhausted}
+		for optionID, votes := range counts {
+			result := schema.OptionResult{
+				PollOptionID:   optionID,
+				PollOptionText: texts[optionID],
+				Votes:          votes,
+			}
+			if active > 0 {
+				result.Percentage = math.Round(float64(votes)/float64(active)*10000) / 100
+			}
+			current.Counts = append(current.Counts, result)
+		}
+		sort.Slice(current.Counts, func(i, j int) bool {
+			return current.Counts[i].PollOptionID < current.Counts[j].PollOptionID
+		})
+
+		// A majority of the active ballots, or the last option standing, wins
+		for optionID, votes := range counts {
+			if (active > 0 && votes*2 > active) || len(remaining) == 1 {
+				winner := optionID
+				rounds = append(rounds, current)
+				return rounds, &winner
+			}
+		}
+		if active == 0 {
+			rounds = append(rounds, current)
+			return rounds, nil
+		}
+
+		loser := current.Counts[0].PollOptionID
+		for _, result := range current.Counts[1:] {
+			optionID := result.PollOptionID
+			switch {
+			case counts[optionID] < counts[loser]:
+				loser = optionID
+			case counts[optionID] == counts[loser] && firstRound[optionID] < firstRound[loser]:
+				loser = optionID
+			case counts[optionID] == counts[loser] && firstRound[optionID] == firstRound[loser] && optionID > loser:
+				loser = optionID
+			}
+		}
+		current.Eliminated = []uint{loser}
+		delete(remaining, loser)
+		rounds = append(rounds, current)
+	}
+
+	return rounds, nil
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"votes-api/schema"
+	"votes-api/store"
+)
+
+// Submitting a vote touches two stores: the vote in votes-api's cache and the
+// voter's VoteHistory in voter-api. PostVote runs it as a small saga:
+//
+//  1. the vote is written to an outbox as pending,
+//  2. the vote link is added to the voter's VoteHistory,
+//  3. the vote is committed (stored, tallied and removed from the outbox).
+//
+// If step 2 fails the pending vote is dropped; if step 3 fails the history
+// entry is removed again. Whatever is left half done, e.g. because votes-api
+// died in between, is repaired by the reconciler, which finishes a pending
+// vote the voter's history already references and drops any other. History
+// changes that fail outside the saga are queued as fixes for it.
+const reconcilerLockKey = "reconciler-lock-votes"
+
+func voteLink(pv store.PendingVote) string {
+	return fmt.Sprintf("/votes/%d", pv.VoteID)
+}
+
+// abortVote drops a pending vote and gives the voter's ballot back
+func (p *VotesAPI) abortVote(ctx context.Context, pv store.PendingVote) error {
+	if err := p.votes.DropPending(ctx, pv.VoteID); err != nil {
+		return err
+	}
+	return p.votes.ReleaseBallot(ctx, pv.PollID, pv.VoterID, pv.VoteID)
+}
+
+// pollPending lists the votes in the poll that are still being cast
+func (p *VotesAPI) pollPending(ctx context.Context, pollID string) ([]store.PendingVote, error) {
+	all, err := p.votes.DuePending(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var pending []store.PendingVote
+	for _, pv := range all {
+		if pv.PollID == pollID {
+			pending = append(pending, pv)
+		}
+	}
+	return pending, nil
+}
+
+// RunReconciler periodically repairs votes the saga left half done. Pending
+// votes younger than grace may still be in flight and are left alone. Like
+// poll-api's scheduler, a short-lived lock lets only one replica work on
+// each tick.
+func (p *VotesAPI) RunReconciler(ctx context.Context, interval time.Duration, grace time.Duration) {
+	owner, _ := os.Hostname()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			acquired, err := p.votes.TryLock(ctx, reconcilerLockKey, owner, interval)
+			if err != nil {
+				log.Println("Reconciler: error acquiring lock: " + err.Error())
+				continue
+			}
+			if !acquired {
+				continue
+			}
+
+			p.reconcilePendingVotes(ctx, time.Now().Add(-grace))
+			p.reconcileVoteHistories(ctx, time.Now().Add(-grace))
+			p.reconcileDeletedVoters(ctx, time.Now().Add(-grace))
+		}
+	}
+}
+
+// reconcilePendingVotes finishes or drops every pending vote written before
+// cutoff, depending on whether the voter's history references it
+func (p *VotesAPI) reconcilePendingVotes(ctx context.Context, cutoff time.Time) {
+	due, err := p.votes.DuePending(ctx, cutoff)
+	if err != nil {
+		log.Println("Reconciler: error reading pending votes: " + err.Error())
+		return
+	}
+
+	for _, pv := range due {
+		voter, err := p.voterAPI.Get(ctx, pv.Vote.VoterID)
+		if err != nil && err != errVoterNotFound {
+			log.Printf("Reconciler: could not check voter for vote %d: %v", pv.VoteID, err)
+			continue
+		}
+
+		if err == nil && containsVote(voter.VoteHistory, voteLink(pv)) {
+			err = p.votes.CommitPending(ctx, pv.VoteID)
+			log.Printf("Reconciler: committed vote %d: %v", pv.VoteID, err)
+		} else {
+			err = p.abortVote(ctx, pv)
+			log.Printf("Reconciler: dropped vote %d: %v", pv.VoteID, err)
+		}
+	}
+}
+
+// queueHistoryFix leaves the voter's VoteHistory entry for the vote to the
+// reconciler, after a call to voter-api about it failed
+func (p *VotesAPI) queueHistoryFix(ctx context.Context, voterLink string, voteID uint) {
+	fix := store.HistoryFix{VoterLink: voterLink, VoteID: voteID}
+	if err := p.votes.QueueHistoryFix(ctx, fix); err != nil {
+		log.Printf("Failed to queue a fix of /votes/%d in %s: %v", voteID, voterLink, err)
+	}
+}
+
+// reconcileVoteHistories works through the history fixes queued before
+// cutoff: entries of stored votes are brought up to date and entries of
+// votes which were never stored or were retracted are removed
+func (p *VotesAPI) reconcileVoteHistories(ctx context.Context, cutoff time.Time) {
+	fixes, err := p.votes.DueHistoryFixes(ctx, cutoff)
+	if err != nil {
+		log.Println("Reconciler: error reading history fixes: " + err.Error())
+		return
+	}
+
+	for _, fix := range fixes {
+		link := fmt.Sprintf("/votes/%d", fix.VoteID)
+
+		_, err := p.voterAPI.Get(ctx, fix.VoterLink)
+		if err == errVoterNotFound {
+			// The history went with the voter
+			err = p.votes.DropHistoryFix(ctx, fix)
+			log.Printf("Reconciler: dropped fix of %s in deleted %s: %v", link, fix.VoterLink, err)
+			continue
+		} else if err != nil {
+			log.Printf("Reconciler: could not check %s: %v", fix.VoterLink, err)
+			continue
+		}
+
+		vote, err := p.votes.Get(ctx, fix.VoteID)
+		if err == nil && vote.VoterID == fix.VoterLink {
+			// Adding an entry that is there already changes nothing
+			err = p.voterAPI.AddToHistory(ctx, fix.VoterLink, historyEntry(vote))
+			if err == nil {
+				err = p.voterAPI.UpdateHistory(ctx, fix.VoterLink, historyEntry(vote))
+			}
+			log.Printf("Reconciler: brought %s in %s up to date: %v", link, fix.VoterLink, err)
+		} else if err == nil || err == store.ErrNotFound {
+			// Pending votes are not stored yet and are left to
+			// reconcilePendingVotes. An ID that is taken by a vote which is
+			// not stored belongs to a pending vote, unless the vote has an
+			// audit trail: those IDs are kept after a retraction.
+			if err == store.ErrNotFound {
+				if pending, err := p.isPending(ctx, fix.VoteID); err != nil || pending {
+					continue
+				}
+			}
+			err = p.voterAPI.RemoveFromHistory(ctx, fix.VoterLink, link)
+			log.Printf("Reconciler: removed %s from %s: %v", link, fix.VoterLink, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		if err := p.votes.DropHistoryFix(ctx, fix); err != nil {
+			log.Printf("Reconciler: could not drop the fix of %s in %s: %v", link, fix.VoterLink, err)
+		}
+	}
+}
+
+// isPending reports whether the vote ID, which is not stored, belongs to a
+// pending vote
+func (p *VotesAPI) isPending(ctx context.Context, id uint) (bool, error) {
+	exists, err := p.votes.Exists(ctx, id)
+	if err != nil || !exists {
+		return false, err
+	}
+	trail, err := p.votes.AuditTrail(ctx, id)
+	return len(trail) == 0, err
+}
+
+// reconcileDeletedVoters anonymizes the votes of voters queued before
+// cutoff. voter-api queues a voter before deleting them and asks for the
+// anonymization afterwards, so a voter still queued was either deleted
+// without it or not deleted at all.
+func (p *VotesAPI) reconcileDeletedVoters(ctx context.Context, cutoff time.Time) {
+	due, err := p.votes.DueAnonymize(ctx, cutoff)
+	if err != nil {
+		log.Println("Reconciler: error reading voters to anonymize: " + err.Error())
+		return
+	}
+
+	for _, voterID := range due {
+		_, err := p.voterAPI.Get(ctx, "/voters/"+voterID)
+		if err == nil {
+			err = p.votes.DropAnonymize(ctx, voterID)
+			log.Printf("Reconciler: voter %s was not deleted, not anonymizing: %v", voterID, err)
+		} else if err == errVoterNotFound {
+			anonymized, err := p.votes.AnonymizeVoter(ctx, voterID)
+			log.Printf("Reconciler: anonymized %d votes of deleted voter %s: %v", anonymized, voterID, err)
+		} else {
+			log.Printf("Reconciler: could not check voter %s: %v", voterID, err)
+		}
+	}
+}
+
+func containsVote(history []schema.VoteHistoryEntry, link string) bool {
+	for _, entry := range history {
+		if entry.VoteLink == link {
+			return true
+		}
+	}
+	return false
+}
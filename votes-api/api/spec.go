@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+
+	"votes-api/schema"
+
+	"github.com/gin-gonic/gin"
+)
+
+const specTitle = "Votes API"
+
+// dependencyErrors are the answers of routes that call poll-api or
+// voter-api: 502 if the service answered wrongly, 503 if it could not be
+// reached
+var dependencyErrors = []int{http.StatusBadGateway, http.StatusServiceUnavailable}
+
+// RegisterRoutes adds the API's routes to r. Each one needs an entry in
+// operations; spec_test.go checks that.
+func (p *VotesAPI) RegisterRoutes(r gin.IRouter) {
+	r.GET("/votes", p.GetAllVotes)
+	r.POST("/votes", p.PostVote)
+	r.GET("/votes/:id", p.GetVoteByID)
+	r.PUT("/votes/:id", p.PutVote)
+	r.DELETE("/votes/:id", p.DeleteVote)
+	r.GET("/votes/:id/audit", p.GetVoteAudit)
+	r.GET("/polls/:id/results", p.GetPollResults)
+	r.GET("/polls/:id/votes", p.CountPollVotes)
+	r.DELETE("/polls/:id/votes", p.DeletePollVotes)
+	r.POST("/voters/:id/anonymize", p.AnonymizeVoterVotes)
+	r.POST("/voters/:id/anonymize-queue", p.QueueAnonymizeVoter)
+	r.GET("/openapi.json", OpenAPI)
+}
+
+// operations documents every route RegisterRoutes adds, for the OpenAPI
+// document
+var operations = []operation{
+	{
+		method:  http.MethodGet,
+		path:    "/votes",
+		summary: "List votes, page by page",
+		query: append(pageQuery("id, time"),
+			param{"poll", "Only votes in the poll with this ID"},
+			param{"voter", "Only votes by the voter with this ID"},
+			param{"option", "Only votes for this option; needs poll"},
+			param{"from", "Only votes cast at or after this RFC 3339 time"},
+			param{"to", "Only votes cast at or before this RFC 3339 time"}),
+		responses: append([]response{pageResponse([]schema.Vote{})}, errorResponses(http.StatusBadRequest, http.StatusInternalServerError)...),
+	},
+	{
+		method:  http.MethodPost,
+		path:    "/votes",
+		summary: "Cast a vote; a zero VoteID is picked by the API",
+		body:    schema.Vote{},
+		responses: append([]response{createdResponse(schema.Vote{}, "vote")},
+			errorResponses(append([]int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError}, dependencyErrors...)...)...),
+	},
+	{
+		method:    http.MethodGet,
+		path:      "/votes/:id",
+		summary:   "Get a vote",
+		responses: append([]response{okResponse(schema.Vote{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		method:  http.MethodPut,
+		path:    "/votes/:id",
+		summary: "Change the ballot of a vote while its poll allows it",
+		body:    schema.Vote{},
+		responses: append([]response{okResponse(schema.Vote{})},
+			errorResponses(append([]int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError}, dependencyErrors...)...)...),
+	},
+	{
+		method:  http.MethodDelete,
+		path:    "/votes/:id",
+		summary: "Retract a vote while its poll allows it",
+		responses: append([]response{okResponse(messageBody{})},
+			errorResponses(append([]int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError}, dependencyErrors...)...)...),
+	},
+	{
+		method:    http.MethodGet,
+		path:      "/votes/:id/audit",
+		summary:   "Get the changes made to a vote after it was cast",
+		responses: append([]response{okResponse([]schema.VoteAudit{})}, errorResponses(http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...),
+	},
+	{
+		method:  http.MethodGet,
+		path:    "/polls/:id/results",
+		summary: "Get the results of a poll",
+		responses: append([]response{okResponse(schema.PollResults{})},
+			errorResponses(append([]int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError}, dependencyErrors...)...)...),
+	},
+	{
+		method:    http.MethodGet,
+		path:      "/polls/:id/votes",
+		summary:   "Count the votes in a poll, including the ones still being cast",
+		responses: append([]response{okResponse(schema.PollVoteCount{})}, errorResponses(http.StatusBadRequest, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodDelete,
+		path:      "/polls/:id/votes",
+		summary:   "Delete every vote in a poll; poll-api does this when a poll is deleted",
+		responses: append([]response{okResponse(messageBody{})}, errorResponses(http.StatusBadRequest, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodPost,
+		path:      "/voters/:id/anonymize",
+		summary:   "Take the voter out of their votes; voter-api does this when a voter is deleted",
+		responses: append([]response{okResponse(messageBody{})}, errorResponses(http.StatusBadRequest, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodPost,
+		path:      "/voters/:id/anonymize-queue",
+		summary:   "Queue the voter to have their votes taken out if they are deleted; voter-api does this before it deletes a voter",
+		responses: append([]response{okResponse(messageBody{})}, errorResponses(http.StatusBadRequest, http.StatusInternalServerError)...),
+	},
+	{
+		method:    http.MethodGet,
+		path:      "/openapi.json",
+		summary:   "This document",
+		responses: append([]response{okResponse(map[string]interface{}{})}, errorResponses(http.StatusInternalServerError)...),
+	},
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"votes-api/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestSpecCoversRoutes fails when a route is missing from the OpenAPI
+// document, or the document lists a route that does not exist
+func TestSpecCoversRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	NewVotesAPI(store.NewMemoryVoteRepository(), "http://localhost:0", "http://localhost:0").RegisterRoutes(r)
+
+	if err := CheckSpec(r.Routes()); err != nil {
+		t.Error(err)
+	}
+
+	registered := map[string]bool{}
+	for _, route := range r.Routes() {
+		registered[route.Method+" "+route.Path] = true
+	}
+	for _, op := range operations {
+		if !registered[op.method+" "+op.path] {
+			t.Errorf("spec.go documents %s %s, which is not registered", op.method, op.path)
+		}
+	}
+}
+
+// TestSpecListsInternalError fails when an operation does not document the
+// 500 internal_error problem every route may answer with
+func TestSpecListsInternalError(t *testing.T) {
+	for _, op := range operations {
+		listed := false
+		for _, r := range op.responses {
+			listed = listed || r.status == http.StatusInternalServerError
+		}
+		if !listed {
+			t.Errorf("spec.go does not list a 500 response for %s %s", op.method, op.path)
+		}
+	}
+}
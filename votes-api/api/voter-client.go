@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"votes-api/schema"
+)
+
+var errVoterNotFound = fmt.Errorf("voter not found")
+
+// voterClient reads voters from voter-api and keeps their VoteHistory in
+// step with the votes
+type voterClient struct {
+	*serviceClient
+}
+
+func newVoterClient(baseURL string) *voterClient {
+	return &voterClient{newServiceClient("voter-api", baseURL)}
+}
+
+// Get fetches a voter by its link, e.g. "/voters/1"
+func (v *voterClient) Get(ctx context.Context, voterLink string) (schema.Voter, error) {
+	var voter schema.Voter
+
+	resp, err := v.do(ctx, http.MethodGet, voterLink, nil)
+	if err != nil {
+		return voter, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return voter, resp.decode(&voter)
+	case http.StatusNotFound:
+		return voter, errVoterNotFound
+	}
+	return voter, v.unexpected(resp, "reading voter "+voterLink)
+}
+
+// historyEntry is the VoteHistory entry voter-api keeps for a vote
+func historyEntry(vote schema.Vote) schema.VoteHistoryEntry {
+	entry := schema.VoteHistoryEntry{
+		PollLink: vote.PollID,
+		VoteLink: fmt.Sprintf("/votes/%d", vote.VoteID),
+		VoteDate: vote.CastAt,
+	}
+	switch {
+	case len(vote.VoteRanking) > 0:
+		entry.Options = vote.VoteRanking
+	case len(vote.VoteSelections) > 0:
+		entry.Options = vote.VoteSelections
+	case len(vote.VoteScores) > 0:
+		for _, rating := range vote.VoteScores {
+			entry.Options = append(entry.Options, rating.PollOptionID)
+		}
+	default:
+		entry.Options = []uint{vote.VoteValue}
+	}
+	return entry
+}
+
+// AddToHistory appends the entry to the voter's VoteHistory
+func (v *voterClient) AddToHistory(ctx context.Context, voterLink string, entry schema.VoteHistoryEntry) error {
+	// voter-api answers 409 if the link is in the history already, e.g.
+	// when the add is retried after its response was lost
+	return v.putHistory(ctx, voterLink+"/history", entry, "adding", http.StatusConflict)
+}
+
+// UpdateHistory replaces the voter's VoteHistory entry for the same vote,
+// e.g. after the vote was changed
+func (v *voterClient) UpdateHistory(ctx context.Context, voterLink string, entry schema.VoteHistoryEntry) error {
+	voteID := strings.TrimPrefix(entry.VoteLink, "/votes/")
+	return v.putHistory(ctx, voterLink+"/history/"+voteID, entry, "updating", http.StatusOK)
+}
+
+// putHistory PUTs the entry to path, accepting 200 and the status done as
+// success
+func (v *voterClient) putHistory(ctx context.Context, path string, entry schema.VoteHistoryEntry, action string, done int) error {
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	resp, err := v.do(ctx, http.MethodPut, path, entryJSON)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != done {
+		return v.unexpected(resp, fmt.Sprintf("%s %s in %s", action, entry.VoteLink, path))
+	}
+	return nil
+}
+
+// RemoveFromHistory takes voteLink out of the voter's VoteHistory. A link
+// that is not in the history counts as removed.
+func (v *voterClient) RemoveFromHistory(ctx context.Context, voterLink string, voteLink string) error {
+	voteID := strings.TrimPrefix(voteLink, "/votes/")
+	resp, err := v.do(ctx, http.MethodDelete, voterLink+"/history/"+voteID, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return v.unexpected(resp, fmt.Sprintf("removing %s from %s", voteLink, voterLink))
+	}
+	return nil
+}
@@ -1,211 +1,476 @@
 package api
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"votes-api/schema"
+	"votes-api/store"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis/v8"
-	"github.com/nitishm/go-rejson/v4"
 )
 
-const (
-	RedisNilError        = "redis: nil"
-	RedisDefaultLocation = "0.0.0.0:6379"
-)
-
-type cache struct {
-	client  *redis.Client
-	helper  *rejson.Handler
-	context context.Context
-}
-
 type VotesAPI struct {
-	cache
+	votes    store.VoteRepository
+	voterAPI *voterClient
+	pollAPI  *pollClient
 }
 
-func NewVotesAPI(location string) (*VotesAPI, error) {
-
-	client := redis.NewClient(&redis.Options{
-		Addr: location,
-	})
+func NewVotesAPI(votes store.VoteRepository, voterAPIURL string, pollAPIURL string) *VotesAPI {
+	return &VotesAPI{
+		votes:    votes,
+		voterAPI: newVoterClient(voterAPIURL),
+		pollAPI:  newPollClient(pollAPIURL),
+	}
+}
 
-	ctx := context.Background()
+// idParam reads a numeric ID from the named param, responding with 400 if
+// it is not valid
+func idParam(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil {
+		badRequest(c, "Invalid ID: "+c.Param(name))
+		return 0, false
+	}
+	return uint(id), true
+}
 
-	err := client.Ping(ctx).Err()
+// canonicalID parses the poll or voter ID of a posted vote, given as an ID
+// or as a link with the prefix, and writes it the one way poll-api and
+// voter-api would
+func canonicalID(value string, prefix string) (string, bool) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(value, prefix), 10, 64)
 	if err != nil {
-		log.Println("Error connecting to redis" + err.Error())
-		return nil, err
+		return "", false
 	}
+	return strconv.FormatUint(id, 10), true
+}
 
-	jsonHelper := rejson.NewReJSONHandler()
-	jsonHelper.SetGoRedisClientWithContext(ctx, client)
+// pageParams reads the ?limit=&cursor=&sort= query params of a list
+// endpoint, responding with 400 if they are not valid. sort takes "id" or
+// one of the given orders, prefixed with "-" for descending order.
+func pageParams(c *gin.Context, sorts ...string) (store.Page, bool) {
+	page := store.Page{Cursor: c.Query("cursor"), Limit: store.DefaultPageLimit}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > store.MaxPageLimit {
+			msg := fmt.Sprintf("limit must be a number between 1 and %d", store.MaxPageLimit)
+			badRequest(c, msg)
+			return page, false
+		}
+		page.Limit = n
+	}
 
-	return &VotesAPI{
-		cache: cache{
-			client:  client,
-			helper:  jsonHelper,
-			context: ctx,
-		},
-	}, nil
+	if sort := c.Query("sort"); sort != "" {
+		page.Desc = strings.HasPrefix(sort, "-")
+		page.Sort = strings.TrimPrefix(sort, "-")
+		valid := page.Sort == store.SortByID
+		for _, allowed := range sorts {
+			valid = valid || page.Sort == allowed
+		}
+		if !valid {
+			orders := strings.Join(append([]string{store.SortByID}, sorts...), ", ")
+			msg := fmt.Sprintf("sort must be one of %s, optionally prefixed with -", orders)
+			badRequest(c, msg)
+			return page, false
+		}
+	}
+	return page, true
+}
 
+// setNextCursor tells the client where the next page starts. The body stays
+// a plain list so existing clients keep working.
+func setNextCursor(c *gin.Context, next string) {
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+	}
 }
 
 func (p *VotesAPI) PostVote(c *gin.Context) {
 	// Read the payload
 	var newVote schema.Vote
 
-	if err := c.ShouldBindJSON(&newVote); err != nil {
-		log.Println("Error binding JSON: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+	if !bindJSON(c, &newVote) {
 		return
 	}
-
-	// modifying the voter and poll id to be in the right format for hyperlinks
-	newVote.VoterID = "/voters/" + newVote.VoterID
-	newVote.PollID = "/polls/" + newVote.PollID
-
-	VoteJSON, err := json.Marshal(newVote)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize Vote data"})
+	// The IDs key the voter's ballot, the tallies and the indexes, so "01"
+	// must end up as the same poll or voter as "1". Their binding rules
+	// check the format; changes to a vote may leave them out, so the rules
+	// cannot require them.
+	var invalid []FieldError
+	pollID, ok := canonicalID(newVote.PollID, "/polls/")
+	if !ok {
+		invalid = append(invalid, FieldError{Field: "PollID", Reason: "is required"})
+	}
+	voterID, ok := canonicalID(newVote.VoterID, "/voters/")
+	if !ok {
+		invalid = append(invalid, FieldError{Field: "VoterID", Reason: "is required"})
+	}
+	if len(invalid) > 0 {
+		problem := newProblem(c, http.StatusBadRequest, codeValidationFailed, "The body has invalid fields")
+		problem.Errors = invalid
+		sendProblem(c, http.StatusBadRequest, problem)
 		return
 	}
 
-	// Check if the vote id already exists
-	voteKey := fmt.Sprintf("vote-%d", newVote.VoteID)
+	// modifying the voter and poll id to be in the right format for hyperlinks
+	newVote.VoterID = "/voters/" + voterID
+	newVote.PollID = "/polls/" + pollID
+
+	// The server decides when a vote was cast
+	castAt := time.Now().UTC()
+	newVote.CastAt = &castAt
+
+	// Votes posted without a VoteID get the next free one. IDs clients
+	// picked themselves are skipped.
+	allocate := newVote.VoteID == 0
+	for {
+		if allocate {
+			id, err := p.votes.NextID(c)
+			if err != nil {
+				internalError(c, "Failed to allocate a vote ID")
+				return
+			}
+			newVote.VoteID = id
+		}
 
-	ks, _ := p.client.Keys(p.context, "vote-*").Result()
-	for _, key := range ks {
-		if voteKey == key {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Vote already exists (ID is not unique)"})
+		// Check if the vote id already exists
+		exists, err := p.votes.Exists(c, newVote.VoteID)
+		if err != nil {
+			internalError(c, "Failed to check the vote ID")
+			return
+		}
+		if !exists {
+			break
+		}
+		if !allocate {
+			conflict(c, fmt.Sprintf("Vote %d already exists or was retracted", newVote.VoteID))
 			return
 		}
 	}
 
-	// Check if the voter exists
-	voterAPIURL := envVarOrDefault("VOTER_API_URL", "http://localhost:1080")
-
-	vReq := voterAPIURL + newVote.VoterID
-	vResp, err := http.Get(vReq)
+	// Claim the voter's ballot in this poll before anything else is written.
+	// It is given back if the vote is not stored in the end.
+	claimed, err := p.votes.ClaimBallot(c, pollID, voterID, newVote.VoteID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		internalError(c, "Failed to check the voter's ballot")
+		return
+	}
+	if !claimed {
+		conflict(c, fmt.Sprintf("Voter %s has already voted in poll %s.", voterID, pollID))
 		return
 	}
-	if vResp.StatusCode == http.StatusBadRequest {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "The voter doesn't exist."})
+	sagaStarted := false
+	defer func() {
+		// Once the saga has started it gives the ballot back itself
+		if sagaStarted {
+			return
+		}
+		if err := p.votes.ReleaseBallot(c, pollID, voterID, newVote.VoteID); err != nil {
+			log.Println("Failed to release ballot: " + err.Error())
+		}
+	}()
+
+	// Check if the voter exists
+	_, err = p.voterAPI.Get(c.Request.Context(), newVote.VoterID)
+	if err == errVoterNotFound {
+		notFound(c, "The voter doesn't exist.")
+		return
+	} else if err != nil {
+		log.Printf("Error getting voter %s: %v", newVote.VoterID, err)
+		dependencyError(c, err, "Could not check the voter: "+err.Error())
 		return
 	} else {
 		log.Println("The voter exists.")
 	}
 
 	// Check if the poll exists
-	pollAPIURL := envVarOrDefault("POLLS_API_URL", "http://localhost:2080")
-	pReq := pollAPIURL + newVote.PollID
-	pResp, err := http.Get(pReq)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+	poll, err := p.pollAPI.Get(c.Request.Context(), newVote.PollID)
+	if err == errPollNotFound {
+		notFound(c, "The poll doesn't exist.")
 		return
-	}
-	if pResp.StatusCode == http.StatusBadRequest {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "The poll doesn't exist."})
+	} else if err != nil {
+		log.Printf("Error getting poll %s: %v", newVote.PollID, err)
+		dependencyError(c, err, "Could not check the poll: "+err.Error())
 		return
 	} else {
 		log.Println("The poll exists.")
 	}
 
-	// Add the vote to the voter's VoteHistory
-	payload := fmt.Sprintf("/votes/" + strconv.Itoa(int(newVote.VoteID)))
-	targetURL := voterAPIURL + newVote.VoterID + "/history"
+	// Only polls that are open accept votes
+	if !poll.AcceptsVotesAt(time.Now()) {
+		msg := fmt.Sprintf("The poll is not open for voting (status: %s).", poll.PollStatus)
+		conflict(c, msg)
+		return
+	}
 
-	requestBody := strings.NewReader(payload)
-	request, _ := http.NewRequest("PUT", targetURL, requestBody)
-	httpClient := &http.Client{}
-	response, err := httpClient.Do(request)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add vote to the voter's VoteHistory"})
+	// The vote must fit the poll's type and options
+	if ballotErr := validateBallot(poll, &newVote); ballotErr != nil {
+		invalidBallot(c, ballotErr, poll)
 		return
-	} else {
-		log.Println(payload)
-		log.Println(targetURL)
-		log.Println(response)
 	}
 
-	// Add the vote to redis
-	err = p.client.Set(p.context, voteKey, VoteJSON, 0).Err()
+	// Store the vote and the voter's history entry as a saga (see saga.go).
+	// The ID check above only saves work: BeginPending is what takes the ID.
+	var pv store.PendingVote
+	for {
+		pv = store.PendingVote{VoteID: newVote.VoteID, PollID: pollID, VoterID: voterID, Vote: newVote}
+		err = p.votes.BeginPending(c, pv)
+		if err != store.ErrExists || !allocate {
+			break
+		}
+
+		// A client picked the allocated ID in the meantime, so the ballot
+		// moves to the next one
+		if err := p.votes.ReleaseBallot(c, pollID, voterID, newVote.VoteID); err != nil {
+			internalError(c, "Failed to release the voter's ballot")
+			return
+		}
+		id, err := p.votes.NextID(c)
+		if err != nil {
+			internalError(c, "Failed to allocate a vote ID")
+			return
+		}
+		newVote.VoteID = id
+		claimed, err := p.votes.ClaimBallot(c, pollID, voterID, newVote.VoteID)
+		if err != nil {
+			internalError(c, "Failed to check the voter's ballot")
+			return
+		}
+		if !claimed {
+			conflict(c, fmt.Sprintf("Voter %s has already voted in poll %s.", voterID, pollID))
+			return
+		}
+	}
+	if err == store.ErrExists {
+		conflict(c, fmt.Sprintf("Vote %d already exists or was retracted", newVote.VoteID))
+		return
+	} else if err != nil {
+		internalError(c, "Failed to store Vote in cache")
+		return
+	}
+	sagaStarted = true
+
+	// poll-api closes a poll or changes its options before it counts the
+	// poll's votes, pending ones included. Reading the poll again now that
+	// the vote is pending means such a change either counts this vote or is
+	// seen here.
+	poll, err = p.pollAPI.Get(c.Request.Context(), newVote.PollID)
+	if err == nil && (!poll.AcceptsVotesAt(time.Now()) || validateBallot(poll, &newVote) != nil) {
+		err = errPollChanged
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store Vote in cache"})
+		if err := p.abortVote(c, pv); err != nil {
+			log.Println("Failed to drop pending vote, leaving it to the reconciler: " + err.Error())
+		}
+		if err == errPollChanged || err == errPollNotFound {
+			conflict(c, "The poll was closed, deleted or changed while the vote was cast.")
+		} else {
+			dependencyError(c, err, "Could not check the poll: "+err.Error())
+		}
 		return
-	} else {
-		log.Println(voteKey)
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Vote added to cache successfully"})
+	// Add the vote to the voter's VoteHistory
+	if err := p.voterAPI.AddToHistory(c.Request.Context(), newVote.VoterID, historyEntry(newVote)); err != nil {
+		log.Println("Failed to add vote to the voter's VoteHistory: " + err.Error())
+		// The entry may have been added with only the answer lost
+		p.queueHistoryFix(c, newVote.VoterID, newVote.VoteID)
+		if err := p.abortVote(c, pv); err != nil {
+			log.Println("Failed to drop pending vote, leaving it to the reconciler: " + err.Error())
+		}
+		dependencyError(c, err, "Failed to add vote to the voter's VoteHistory")
+		return
 	}
+
+	// Add the vote to the cache and count it towards the poll's results
+	if err := p.votes.CommitPending(c, newVote.VoteID); err != nil {
+		log.Println("Failed to commit vote: " + err.Error())
+		if err := p.voterAPI.RemoveFromHistory(c.Request.Context(), newVote.VoterID, voteLink(pv)); err != nil {
+			log.Println("Failed to undo VoteHistory entry, leaving it to the reconciler: " + err.Error())
+			p.queueHistoryFix(c, newVote.VoterID, newVote.VoteID)
+		} else if err := p.abortVote(c, pv); err != nil {
+			log.Println("Failed to drop pending vote, leaving it to the reconciler: " + err.Error())
+		}
+		if err == store.ErrNotFound {
+			// DeletePollVotes dropped it
+			conflict(c, "The poll's votes were deleted while the vote was cast.")
+		} else {
+			internalError(c, "Failed to store Vote in cache")
+		}
+		return
+	}
+
+	log.Printf("vote-%d", newVote.VoteID)
+	c.Header("Location", fmt.Sprintf("/votes/%d", newVote.VoteID))
+	c.JSON(http.StatusCreated, newVote)
 }
 
 func (p *VotesAPI) GetAllVotes(c *gin.Context) {
-	var voteList []schema.Vote
-	var voteItem schema.Vote
-
-	pattern := "vote-*"
-	ks, _ := p.client.Keys(p.context, pattern).Result()
-
-	for _, key := range ks {
-		value, err := p.client.Get(c, key).Result()
-		if err == redis.Nil {
-			fmt.Printf("Key %s does not exist in Redis\n", key)
-		} else if err != nil {
-			log.Fatalf("Error getting key %s: %v", key, err)
-		} else {
-			valueBytes := []byte(value)
-			err = json.Unmarshal(valueBytes, &voteItem)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find publication in cache with id=" + key})
-			}
+	page, ok := pageParams(c, store.SortByTime)
+	if !ok {
+		return
+	}
+	filter, ok := voteFilterParams(c)
+	if !ok {
+		return
+	}
 
-			voteList = append(voteList, voteItem)
-		}
+	voteList, next, err := p.votes.List(c, filter, page)
+	if err == store.ErrInvalidCursor {
+		badRequest(c, "Invalid cursor: "+page.Cursor)
+		return
+	} else if err != nil {
+		log.Println("Error listing votes: " + err.Error())
+		internalError(c, "Could not list votes")
+		return
 	}
 
+	setNextCursor(c, next)
 	c.JSON(http.StatusOK, voteList)
 }
 
+// voteFilterParams reads the ?poll=&voter=&option=&from=&to= query params
+// of the vote listing, responding with 400 if they are not valid
+func voteFilterParams(c *gin.Context) (store.VoteFilter, bool) {
+	filter := store.VoteFilter{PollID: c.Query("poll"), VoterID: c.Query("voter")}
+
+	if option := c.Query("option"); option != "" {
+		optionID, err := strconv.ParseUint(option, 10, 64)
+		if err != nil {
+			badRequest(c, "Invalid option ID: "+option)
+			return filter, false
+		}
+		if filter.PollID == "" {
+			badRequest(c, "The option filter needs a poll, as option IDs are per poll")
+			return filter, false
+		}
+		id := uint(optionID)
+		filter.OptionID = &id
+	}
+
+	for name, bound := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			badRequest(c, name+" must be an RFC 3339 time: "+value)
+			return filter, false
+		}
+		*bound = &t
+	}
+	return filter, true
+}
+
 func (p *VotesAPI) GetVoteByID(c *gin.Context) {
-	id := c.Param("id")
-	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No vote ID provided"})
+	id, ok := idParam(c, "id")
+	if !ok {
 		return
 	}
 
-	var voteItem schema.Vote
-	value, err := p.client.Get(c, "vote-"+id).Result()
-	if err == redis.Nil {
-		fmt.Printf("Key %s does not exist in Redis\n", id)
+	voteItem, err := p.votes.Get(c, id)
+	if err == store.ErrNotFound {
+		notFound(c, fmt.Sprintf("Vote %d does not exist", id))
+		return
 	} else if err != nil {
-		log.Fatalf("Error getting key %s: %v", id, err)
-	} else {
-		valueBytes := []byte(value)
-		err = json.Unmarshal(valueBytes, &voteItem)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find vote in cache with id=" + id})
+		log.Printf("Error getting vote %d: %v", id, err)
+		internalError(c, "Error getting vote")
+		return
+	}
+
+	c.JSON(http.StatusOK, voteItem)
+}
+
+// DeletePollVotes deletes every vote cast in the poll in the :id param.
+// poll-api calls it when a poll is deleted with its votes. The votes are
+// taken out of their voters' VoteHistory afterwards; links that cannot be
+// removed right away are left to the reconciler, which removes links to
+// votes that do not exist.
+func (p *VotesAPI) DeletePollVotes(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	pollID := strconv.FormatUint(uint64(id), 10)
+
+	// Votes still being cast are dropped first, so their sagas fail to
+	// commit them instead of adding them back to the poll
+	pending, err := p.pollPending(c, pollID)
+	if err != nil {
+		log.Printf("Error listing the pending votes of poll %d: %v", id, err)
+		internalError(c, "Failed to delete the poll's votes")
+		return
+	}
+	for _, pv := range pending {
+		if err := p.abortVote(c, pv); err != nil {
+			log.Printf("Error dropping pending vote %d: %v", pv.VoteID, err)
+			internalError(c, "Failed to delete the poll's votes")
+			return
+		}
+		if err := p.voterAPI.RemoveFromHistory(c.Request.Context(), pv.Vote.VoterID, voteLink(pv)); err != nil {
+			log.Printf("Failed to remove %s from %s, leaving it to the reconciler: %v", voteLink(pv), pv.Vote.VoterID, err)
+			p.queueHistoryFix(c, pv.Vote.VoterID, pv.VoteID)
+		}
+	}
+
+	deleted, err := p.votes.DeletePollVotes(c, pollID)
+	if err != nil {
+		log.Printf("Error deleting the votes of poll %d: %v", id, err)
+		internalError(c, "Failed to delete the poll's votes")
+		return
+	}
+
+	for _, vote := range deleted {
+		link := fmt.Sprintf("/votes/%d", vote.VoteID)
+		if err := p.voterAPI.RemoveFromHistory(c.Request.Context(), vote.VoterID, link); err != nil {
+			log.Printf("Failed to remove %s from %s, leaving it to the reconciler: %v", link, vote.VoterID, err)
+			p.queueHistoryFix(c, vote.VoterID, vote.VoteID)
 		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Deleted %d votes of poll %d", len(deleted), id)})
+}
+
+// QueueAnonymizeVoter queues the voter in the :id param to have their votes
+// anonymized. voter-api calls it before deleting a voter, so the reconciler
+// anonymizes their votes if voter-api cannot ask for it afterwards.
+func (p *VotesAPI) QueueAnonymizeVoter(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
 
-		c.JSON(http.StatusOK, voteItem)
+	if err := p.votes.QueueAnonymize(c, strconv.FormatUint(uint64(id), 10)); err != nil {
+		log.Printf("Error queueing voter %d to be anonymized: %v", id, err)
+		internalError(c, "Failed to queue the voter to be anonymized")
+		return
 	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Queued voter %d to be anonymized", id)})
 }
 
-// Helper function:
-func envVarOrDefault(envVar string, defaultVal string) string {
-	envVal := os.Getenv(envVar)
-	if envVal != "" {
-		return envVal
+// AnonymizeVoterVotes detaches the voter in the :id param from every vote
+// they cast. voter-api calls it after deleting a voter, so the votes keep
+// counting without pointing at a voter that is gone.
+func (p *VotesAPI) AnonymizeVoterVotes(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
 	}
-	return defaultVal
+
+	anonymized, err := p.votes.AnonymizeVoter(c, strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		log.Printf("Error anonymizing the votes of voter %d: %v", id, err)
+		internalError(c, "Failed to anonymize the voter's votes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Anonymized %d votes of voter %d", anonymized, id)})
 }
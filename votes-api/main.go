@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"time"
 	"votes-api/api"
+	"votes-api/store"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -18,14 +21,21 @@ var (
 	cacheURL    string
 	voterAPIURL string
 	pollAPIURL  string
+	storeFlag   string
+
+	reconcileInterval time.Duration
+	reconcileGrace    time.Duration
 )
 
 func processCmdLineFlags() {
 	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
 	flag.StringVar(&cacheURL, "c", "0.0.0.0:6379", "Default cache location")
+	flag.StringVar(&storeFlag, "store", "redis", "Storage backend: redis or memory")
 	flag.StringVar(&voterAPIURL, "http://voter-api:1080", "http://localhost:1080", "Default endpoint for voter API")
 	flag.StringVar(&pollAPIURL, "http://poll-api:2080", "http://localhost:2080", "Default endpoint for poll API")
 	flag.UintVar(&portFlag, "p", 3080, "Default Port")
+	flag.DurationVar(&reconcileInterval, "r", time.Minute, "How often half-submitted votes are repaired")
+	flag.DurationVar(&reconcileGrace, "g", time.Minute, "How old a pending vote must be before it is repaired")
 
 	flag.Parse()
 }
@@ -42,6 +52,7 @@ func setupParms() {
 	processCmdLineFlags()
 
 	cacheURL = envVarOrDefault("REDIS_URL", cacheURL)
+	storeFlag = envVarOrDefault("STORE_BACKEND", storeFlag)
 	voterAPIURL = envVarOrDefault("VOTER_API_URL", voterAPIURL)
 	pollAPIURL = envVarOrDefault("POLLS_API_URL", pollAPIURL)
 	hostFlag = envVarOrDefault("POLL_API_HOST", hostFlag)
@@ -51,28 +62,77 @@ func setupParms() {
 		portFlag = uint(pfNew)
 	}
 
+	riNew, err := time.ParseDuration(envVarOrDefault("VOTES_RECONCILE_INTERVAL", reconcileInterval.String()))
+	if err == nil {
+		reconcileInterval = riNew
+	}
+
+	rgNew, err := time.ParseDuration(envVarOrDefault("VOTES_RECONCILE_GRACE", reconcileGrace.String()))
+	if err == nil {
+		reconcileGrace = rgNew
+	}
+
+}
+
+// newVoteRepository picks the storage backend. The memory backend lets the
+// service run without Redis, but votes only live as long as the process.
+func newVoteRepository() (store.VoteRepository, error) {
+	switch storeFlag {
+	case "redis":
+		return store.NewRedisVoteRepository(cacheURL)
+	case "memory":
+		return store.NewMemoryVoteRepository(), nil
+	}
+	return nil, fmt.Errorf("unknown store backend %q (expected redis or memory)", storeFlag)
+}
+
+func runMigrate(args []string) {
+	votes, err := store.NewRedisVoteRepository(cacheURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := store.RunMigrateCommand(context.Background(), votes.Migrator(), args); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func main() {
 	setupParms()
 	log.Println("Init/cacheURL: " + cacheURL)
+	log.Println("Init/store: " + storeFlag)
 	log.Println("Init/VOTERAPIURL: " + voterAPIURL)
 	log.Println("Init/POLLAPIURL: " + pollAPIURL)
 	log.Println("Init/hostFlag: " + hostFlag)
 	log.Printf("Init/portFlag: %d", portFlag)
+	log.Printf("Init/reconcileInterval: %s", reconcileInterval)
+	log.Printf("Init/reconcileGrace: %s", reconcileGrace)
+
+	// "votes-api migrate ..." migrates the votes stored in Redis and exits
+	if flag.Arg(0) == "migrate" {
+		runMigrate(flag.Args()[1:])
+		return
+	}
 
-	apiHandler, err := api.NewVotesAPI(cacheURL)
+	votes, err := newVoteRepository()
 
 	if err != nil {
 		panic(err)
 	}
 
+	apiHandler := api.NewVotesAPI(votes, voterAPIURL, pollAPIURL)
+
+	go apiHandler.RunReconciler(context.Background(), reconcileInterval, reconcileGrace)
+
 	r := gin.Default()
 	r.Use(cors.Default())
 
-	r.GET("/votes", apiHandler.GetAllVotes)
-	r.POST("/votes", apiHandler.PostVote)
-	r.GET("/votes/:id", apiHandler.GetVoteByID)
+	apiHandler.RegisterRoutes(r)
+	r.NoRoute(api.NoRoute)
+
+	// spec_test.go keeps the OpenAPI document complete, this only warns
+	if err := api.CheckSpec(r.Routes()); err != nil {
+		log.Println("Warning: " + err.Error())
+	}
 
 	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
 	r.Run(serverPath)
@@ -0,0 +1,100 @@
+package schema
+
+import "time"
+
+// Poll mirrors the parts of poll-api's schema.Poll that votes-api relies on
+// when validating a vote
+
+type PollStatus string
+
+const (
+	PollStatusDraft    PollStatus = "draft"
+	PollStatusOpen     PollStatus = "open"
+	PollStatusClosed   PollStatus = "closed"
+	PollStatusArchived PollStatus = "archived"
+)
+
+type PollType string
+
+const (
+	PollTypeSingle   PollType = "single"
+	PollTypeRanked   PollType = "ranked"
+	PollTypeApproval PollType = "approval"
+	PollTypeMulti    PollType = "multi"
+	PollTypeScore    PollType = "score"
+)
+
+type PollOption struct {
+	PollOptionID   uint
+	PollOptionText string
+}
+
+type Poll struct {
+	PollID        uint
+	PollTitle     string
+	PollQuestion  string
+	PollOptions   []PollOption
+	PollStatus    PollStatus
+	PollType      PollType
+	MinSelections uint
+	MaxSelections uint
+	MinScore      uint
+	MaxScore      uint
+	OpensAt       *time.Time
+	ClosesAt      *time.Time
+	// Seconds after it is cast a vote may be changed or retracted
+	VoteChangeSeconds uint
+}
+
+// AcceptsVotesAt reports whether a vote cast at the given time falls inside
+// the poll's voting window. poll-api's scheduler only flips the status
+// periodically, so a draft whose OpensAt has passed is treated as open and an
+// open poll whose ClosesAt has passed as closed.
+func (p *Poll) AcceptsVotesAt(now time.Time) bool {
+	if p.ClosesAt != nil && !now.Before(*p.ClosesAt) {
+		return false
+	}
+	switch p.PollStatus {
+	case PollStatusOpen:
+		return p.OpensAt == nil || !now.Before(*p.OpensAt)
+	case PollStatusDraft:
+		return p.OpensAt != nil && !now.Before(*p.OpensAt)
+	}
+	return false
+}
+
+// VoteChangeWindow is how long after it is cast a vote in the poll may be
+// changed or retracted. Zero means votes are final.
+func (p *Poll) VoteChangeWindow() time.Duration {
+	return time.Duration(p.VoteChangeSeconds) * time.Second
+}
+
+func (p *Poll) HasOption(optionID uint) bool {
+	for _, option := range p.PollOptions {
+		if option.PollOptionID == optionID {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectionLimits returns how many options a vote in an approval or
+// multi-select poll may select
+func (p *Poll) SelectionLimits() (uint, uint) {
+	min, max := p.MinSelections, p.MaxSelections
+	if min == 0 {
+		min = 1
+	}
+	if max == 0 {
+		max = uint(len(p.PollOptions))
+	}
+	return min, max
+}
+
+// ScoreRange returns the range options are rated in for score polls
+func (p *Poll) ScoreRange() (uint, uint) {
+	if p.MinScore == 0 && p.MaxScore == 0 {
+		return 0, 5
+	}
+	return p.MinScore, p.MaxScore
+}
@@ -0,0 +1,20 @@
+package schema
+
+import "time"
+
+// VoteHistoryEntry mirrors voter-api's record of a vote a voter cast
+type VoteHistoryEntry struct {
+	PollLink string
+	VoteLink string
+	VoteDate *time.Time
+	Options  []uint
+}
+
+// Voter mirrors voter-api's schema.Voter, which votes-api reads to check
+// that a voter exists and what its VoteHistory holds
+type Voter struct {
+	VoterID     uint
+	FirstName   string
+	LastName    string
+	VoteHistory []VoteHistoryEntry
+}
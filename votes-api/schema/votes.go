@@ -1,8 +1,41 @@
 package schema
 
+import "time"
+
+type OptionScore struct {
+	PollOptionID uint
+	Score        uint
+}
+
+// The `binding` rules are checked on every vote sent to the API. Whether
+// the ballot fits its poll is checked against the poll.
 type Vote struct {
 	VoteID    uint
-	VoterID   string
-	PollID    string
+	VoterID   string `binding:"omitempty,idlink=voters"`
+	PollID    string `binding:"omitempty,idlink=polls"`
 	VoteValue uint
+	// Ranked polls only: PollOptionIDs in order of preference. VoteValue is
+	// set to the first preference.
+	VoteRanking []uint `binding:"unique"`
+	// Approval and multi-select polls only: the PollOptionIDs selected
+	VoteSelections []uint `binding:"unique"`
+	// Score polls only: a rating for each option the voter scored
+	VoteScores []OptionScore `binding:"unique=PollOptionID"`
+	CastAt     *time.Time    // Set by the server when the vote is submitted
+}
+
+type VoteAction string
+
+const (
+	VoteChanged   VoteAction = "changed"
+	VoteRetracted VoteAction = "retracted"
+)
+
+// VoteAudit records a change made to a vote after it was cast, with the
+// vote as it was before
+type VoteAudit struct {
+	VoteID   uint
+	Action   VoteAction
+	At       time.Time
+	Previous Vote
 }
@@ -0,0 +1,448 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"votes-api/schema"
+)
+
+type memoryPending struct {
+	value []byte
+	begun time.Time
+}
+
+// MemoryVoteRepository keeps votes in process memory. Values are stored
+// marshalled and tallies as string-keyed counters, like in Redis, so both
+// backends behave the same.
+type MemoryVoteRepository struct {
+	mu       sync.Mutex
+	votes    map[uint][]byte
+	pending  map[uint]memoryPending
+	ballots  map[string]uint
+	results  map[string]map[string]string
+	scores   map[string]map[string]string
+	rankings map[string]map[uint]string
+	audits   map[uint][][]byte
+	queued   map[string]time.Time // Voters queued for AnonymizeVoter
+	fixes    map[HistoryFix]time.Time
+	locks    map[string]time.Time
+	lastID   uint
+}
+
+func NewMemoryVoteRepository() *MemoryVoteRepository {
+	return &MemoryVoteRepository{
+		votes:    map[uint][]byte{},
+		pending:  map[uint]memoryPending{},
+		ballots:  map[string]uint{},
+		results:  map[string]map[string]string{},
+		scores:   map[string]map[string]string{},
+		rankings: map[string]map[uint]string{},
+		audits:   map[uint][][]byte{},
+		queued:   map[string]time.Time{},
+		fixes:    map[HistoryFix]time.Time{},
+		locks:    map[string]time.Time{},
+	}
+}
+
+func (m *MemoryVoteRepository) Get(ctx context.Context, id uint) (schema.Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var voteItem schema.Vote
+	value, ok := m.votes[id]
+	if !ok {
+		return voteItem, ErrNotFound
+	}
+	err := json.Unmarshal(value, &voteItem)
+	return voteItem, err
+}
+
+func (m *MemoryVoteRepository) List(ctx context.Context, filter VoteFilter, page Page) ([]schema.Vote, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	votes := map[uint]schema.Vote{}
+	var items []sortItem
+	for id, value := range m.votes {
+		var voteItem schema.Vote
+		if err := json.Unmarshal(value, &voteItem); err != nil {
+			return nil, "", err
+		}
+		if !filter.matches(voteItem) {
+			continue
+		}
+		votes[id] = voteItem
+		item := sortItem{id: id}
+		if page.Sort == SortByTime {
+			item.key = timeKey(castMillis(voteItem))
+		}
+		items = append(items, item)
+	}
+
+	ids, next, err := pageItems(items, page)
+	if err != nil {
+		return nil, "", err
+	}
+	var voteList []schema.Vote
+	for _, id := range ids {
+		voteList = append(voteList, votes[id])
+	}
+	return voteList, next, nil
+}
+
+func (m *MemoryVoteRepository) NextID(ctx context.Context) (uint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID++
+	return m.lastID, nil
+}
+
+func (m *MemoryVoteRepository) Exists(ctx context.Context, id uint) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, stored := m.votes[id]
+	_, pending := m.pending[id]
+	_, audited := m.audits[id]
+	return stored || pending || audited, nil
+}
+
+func (m *MemoryVoteRepository) ClaimBallot(ctx context.Context, pollID string, voterID string, voteID uint) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := ballotKey(pollID, voterID)
+	if _, ok := m.ballots[key]; ok {
+		return false, nil
+	}
+	m.ballots[key] = voteID
+	return true, nil
+}
+
+func (m *MemoryVoteRepository) ReleaseBallot(ctx context.Context, pollID string, voterID string, voteID uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := ballotKey(pollID, voterID)
+	if holder, ok := m.ballots[key]; ok && holder == voteID {
+		delete(m.ballots, key)
+	}
+	return nil
+}
+
+func (m *MemoryVoteRepository) BeginPending(ctx context.Context, pv PendingVote) error {
+	pendingJSON, err := json.Marshal(pv)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, stored := m.votes[pv.VoteID]
+	_, pending := m.pending[pv.VoteID]
+	_, audited := m.audits[pv.VoteID]
+	if stored || pending || audited {
+		return ErrExists
+	}
+	m.pending[pv.VoteID] = memoryPending{value: pendingJSON, begun: time.Now()}
+	return nil
+}
+
+func (m *MemoryVoteRepository) CommitPending(ctx context.Context, voteID uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.pending[voteID]
+	if !ok {
+		return ErrNotFound
+	}
+	var pv PendingVote
+	if err := json.Unmarshal(entry.value, &pv); err != nil {
+		return err
+	}
+	VoteJSON, err := json.Marshal(pv.Vote)
+	if err != nil {
+		return err
+	}
+
+	m.votes[voteID] = VoteJSON
+	m.tallyVote(pv.Vote, 1)
+	delete(m.pending, voteID)
+	return nil
+}
+
+// tallyVote adds a vote to its poll's tally, or with by -1 takes it out.
+// The caller holds the lock.
+func (m *MemoryVoteRepository) tallyVote(vote schema.Vote, by int64) {
+	pollID := linkID(vote.PollID)
+	for _, inc := range voteIncrements(vote) {
+		hashes := m.results
+		if inc.scores {
+			hashes = m.scores
+		}
+		hincrBy(hashes, pollID, inc.field, by)
+	}
+	if len(vote.VoteRanking) == 0 {
+		return
+	}
+	if by < 0 {
+		delete(m.rankings[pollID], vote.VoteID)
+		return
+	}
+	ranking, _ := json.Marshal(vote.VoteRanking)
+	if m.rankings[pollID] == nil {
+		m.rankings[pollID] = map[uint]string{}
+	}
+	m.rankings[pollID][vote.VoteID] = string(ranking)
+}
+
+// hincrBy adds to a counter the way HINCRBY does on a Redis hash
+func hincrBy(hashes map[string]map[string]string, key string, field string, by int64) {
+	if hashes[key] == nil {
+		hashes[key] = map[string]string{}
+	}
+	count, _ := strconv.ParseInt(hashes[key][field], 10, 64)
+	hashes[key][field] = strconv.FormatInt(count+by, 10)
+}
+
+func (m *MemoryVoteRepository) DropPending(ctx context.Context, voteID uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.pending, voteID)
+	return nil
+}
+
+func (m *MemoryVoteRepository) DuePending(ctx context.Context, before time.Time) ([]PendingVote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []PendingVote
+	for _, entry := range m.pending {
+		if entry.begun.After(before) {
+			continue
+		}
+		var pv PendingVote
+		if err := json.Unmarshal(entry.value, &pv); err != nil {
+			continue
+		}
+		due = append(due, pv)
+	}
+	return due, nil
+}
+
+func (m *MemoryVoteRepository) Tally(ctx context.Context, pollID string) (Tally, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rankings []string
+	for _, ranking := range m.rankings[pollID] {
+		rankings = append(rankings, ranking)
+	}
+	return parseTally(m.results[pollID], m.scores[pollID], rankings)
+}
+
+func (m *MemoryVoteRepository) DeletePollVotes(ctx context.Context, pollID string) ([]schema.Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var votes []schema.Vote
+	for id, value := range m.votes {
+		var voteItem schema.Vote
+		if err := json.Unmarshal(value, &voteItem); err != nil {
+			return nil, err
+		}
+		if linkID(voteItem.PollID) != pollID {
+			continue
+		}
+		votes = append(votes, voteItem)
+		delete(m.votes, id)
+		delete(m.ballots, ballotKey(pollID, linkID(voteItem.VoterID)))
+	}
+	delete(m.results, pollID)
+	delete(m.scores, pollID)
+	delete(m.rankings, pollID)
+	return votes, nil
+}
+
+func (m *MemoryVoteRepository) AnonymizeVoter(ctx context.Context, voterID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	anonymized := 0
+	for id, value := range m.votes {
+		var voteItem schema.Vote
+		if err := json.Unmarshal(value, &voteItem); err != nil {
+			return anonymized, err
+		}
+		if voteItem.VoterID == "" || linkID(voteItem.VoterID) != voterID {
+			continue
+		}
+
+		delete(m.ballots, ballotKey(linkID(voteItem.PollID), voterID))
+		voteItem.VoterID = ""
+		VoteJSON, err := json.Marshal(voteItem)
+		if err != nil {
+			return anonymized, err
+		}
+		m.votes[id] = VoteJSON
+		anonymized++
+	}
+	delete(m.queued, voterID)
+	return anonymized, nil
+}
+
+func (m *MemoryVoteRepository) QueueHistoryFix(ctx context.Context, fix HistoryFix) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fixes[fix] = time.Now()
+	return nil
+}
+
+func (m *MemoryVoteRepository) DueHistoryFixes(ctx context.Context, before time.Time) ([]HistoryFix, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []HistoryFix
+	for fix, queued := range m.fixes {
+		if !queued.After(before) {
+			due = append(due, fix)
+		}
+	}
+	return due, nil
+}
+
+func (m *MemoryVoteRepository) DropHistoryFix(ctx context.Context, fix HistoryFix) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.fixes, fix)
+	return nil
+}
+
+func (m *MemoryVoteRepository) QueueAnonymize(ctx context.Context, voterID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queued[voterID] = time.Now()
+	return nil
+}
+
+func (m *MemoryVoteRepository) DropAnonymize(ctx context.Context, voterID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.queued, voterID)
+	return nil
+}
+
+func (m *MemoryVoteRepository) DueAnonymize(ctx context.Context, before time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []string
+	for voterID, queued := range m.queued {
+		if !queued.After(before) {
+			due = append(due, voterID)
+		}
+	}
+	return due, nil
+}
+
+func (m *MemoryVoteRepository) Update(ctx context.Context, id uint, fn func(*schema.Vote) error) (schema.Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var previous schema.Vote
+	value, ok := m.votes[id]
+	if !ok {
+		return previous, ErrNotFound
+	}
+	if err := json.Unmarshal(value, &previous); err != nil {
+		return previous, err
+	}
+	voteItem := previous
+	if err := fn(&voteItem); err != nil {
+		return voteItem, err
+	}
+	voteItem.VoteID = id
+
+	VoteJSON, err := json.Marshal(voteItem)
+	if err != nil {
+		return voteItem, err
+	}
+	audit, err := auditEntry(schema.VoteChanged, previous)
+	if err != nil {
+		return voteItem, err
+	}
+
+	m.tallyVote(previous, -1)
+	m.votes[id] = VoteJSON
+	m.tallyVote(voteItem, 1)
+	m.audits[id] = append(m.audits[id], audit)
+	return voteItem, nil
+}
+
+func (m *MemoryVoteRepository) Delete(ctx context.Context, id uint, fn func(schema.Vote) error) (schema.Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var voteItem schema.Vote
+	value, ok := m.votes[id]
+	if !ok {
+		return voteItem, ErrNotFound
+	}
+	if err := json.Unmarshal(value, &voteItem); err != nil {
+		return voteItem, err
+	}
+	if err := fn(voteItem); err != nil {
+		return voteItem, err
+	}
+	audit, err := auditEntry(schema.VoteRetracted, voteItem)
+	if err != nil {
+		return voteItem, err
+	}
+
+	delete(m.votes, id)
+	m.tallyVote(voteItem, -1)
+	key := ballotKey(linkID(voteItem.PollID), linkID(voteItem.VoterID))
+	if holder, ok := m.ballots[key]; ok && holder == id {
+		delete(m.ballots, key)
+	}
+	m.audits[id] = append(m.audits[id], audit)
+	return voteItem, nil
+}
+
+func (m *MemoryVoteRepository) AuditTrail(ctx context.Context, id uint) ([]schema.VoteAudit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	trail := []schema.VoteAudit{}
+	for _, value := range m.audits[id] {
+		var entry schema.VoteAudit
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return nil, err
+		}
+		trail = append(trail, entry)
+	}
+	return trail, nil
+}
+
+func (m *MemoryVoteRepository) TryLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if expires, ok := m.locks[name]; ok && now.Before(expires) {
+		return false, nil
+	}
+	m.locks[name] = now.Add(ttl)
+	return true, nil
+}
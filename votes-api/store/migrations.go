@@ -0,0 +1,19 @@
+package store
+
+// voteMigrations are the changes made to stored votes, oldest first. Run
+// them with "votes-api migrate up".
+func voteMigrations(docs docStore) []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "store votes as RedisJSON documents",
+			Up:          docs.toDocuments,
+			Down:        docs.fromDocuments,
+		},
+	}
+}
+
+// Migrator runs the vote migrations over this repository's Redis
+func (r *RedisVoteRepository) Migrator() *Migrator {
+	return newMigrator(r.client, "votes", "vote-*", voteMigrations(r.docs))
+}
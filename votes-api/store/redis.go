@@ -0,0 +1,811 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"votes-api/schema"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Key layout. Only the votes themselves start with "vote-" so nothing else
+// shows up in the vote listing.
+const (
+	pendingVotesKey  = "pending-votes"    // Sorted set of pending vote IDs by the time they were begun
+	anonymizeKey     = "anonymize-voters" // Sorted set of voter IDs by the time they were queued for AnonymizeVoter
+	historyFixesKey  = "history-fixes"    // Sorted set of HistoryFix JSON by the time they were queued
+	voteIndexKey     = "index-votes"      // Sorted set of committed vote IDs scored by the ID, for listing
+	voteTimeIndexKey = "index-votes-time" // Sorted set of committed vote IDs scored by castMillis
+	voteCastIndexKey = "index-votes-cast" // Sorted set of castIndexMember for every committed vote, all scored 0
+	nextVoteIDKey    = "next-id-votes"    // Counter NextID allocates from
+)
+
+// castIndexMember is "<timeKey>\x00<zero padded ID>". With every member
+// scored the same Redis keeps the cast index in the order votes are listed
+// by time, so ZRANGEBYLEX reads a page of it directly.
+func castIndexMember(id uint, key string) string {
+	return fmt.Sprintf("%s\x00%020d", key, id)
+}
+
+// parseCastIndexMember reads the ID and sort key back out of a member of the
+// cast index
+func parseCastIndexMember(member string) (sortItem, bool) {
+	key, padded, ok := strings.Cut(member, "\x00")
+	id, err := strconv.ParseUint(padded, 10, 64)
+	return sortItem{id: uint(id), key: key}, ok && err == nil
+}
+
+// Sets of the committed vote IDs in a poll, by a voter and counted towards
+// an option of a poll, for the listing filters
+func pollVotesIndexKey(pollID string) string {
+	return "index-votes-poll-" + pollID
+}
+
+func voterVotesIndexKey(voterID string) string {
+	return "index-votes-voter-" + voterID
+}
+
+func pollOptionIndexKey(pollID string, optionID uint) string {
+	return fmt.Sprintf("index-votes-poll-%s-option-%d", pollID, optionID)
+}
+
+func voteKey(id uint) string {
+	return fmt.Sprintf("vote-%d", id)
+}
+
+func pendingKey(id uint) string {
+	return fmt.Sprintf("pending-vote-%d", id)
+}
+
+// A ballot key records which vote a voter cast in a poll
+func ballotKey(pollID string, voterID string) string {
+	return "ballot-poll-" + pollID + "-voter-" + voterID
+}
+
+func resultsKey(pollID string) string {
+	return "results-poll-" + pollID
+}
+
+func scoresKey(pollID string) string {
+	return "scores-poll-" + pollID
+}
+
+// A vote's audit trail is a list of schema.VoteAudit entries
+func auditKey(id uint) string {
+	return fmt.Sprintf("audit-vote-%d", id)
+}
+
+// Ranked ballots are kept per poll in a hash keyed by vote ID
+func rankedBallotsKey(pollID string) string {
+	return "ballots-poll-" + pollID
+}
+
+// releaseBallotScript deletes a ballot key only if it still belongs to the
+// given vote, so a failed request never frees a ballot claimed by another
+var releaseBallotScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// beginPendingScript records a pending vote unless its ID is taken by a
+// stored, pending or retracted vote
+var beginPendingScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1], KEYS[2], KEYS[3]) > 0 then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[1])
+redis.call("ZADD", KEYS[4], ARGV[2], ARGV[3])
+return 1
+`)
+
+type RedisVoteRepository struct {
+	client *redis.Client
+	docs   docStore
+}
+
+func NewRedisVoteRepository(location string) (*RedisVoteRepository, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr: location,
+	})
+
+	ctx := context.Background()
+
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		log.Println("Error connecting to redis" + err.Error())
+		return nil, err
+	}
+
+	r := &RedisVoteRepository{
+		client: client,
+		docs:   newDocStore(ctx, client),
+	}
+	if err := r.buildIndex(ctx); err != nil {
+		log.Println("Error building vote index: " + err.Error())
+		return nil, err
+	}
+	return r, nil
+}
+
+// buildIndex indexes votes stored before the indexes existed. It only runs
+// while an index is missing and uses SCAN, so it never blocks Redis.
+func (r *RedisVoteRepository) buildIndex(ctx context.Context) error {
+	indexed, err := r.client.Exists(ctx, voteIndexKey, voteTimeIndexKey, voteCastIndexKey).Result()
+	if err != nil || indexed == 3 {
+		return err
+	}
+
+	iter := r.client.Scan(ctx, 0, "vote-*", 1000).Iterator()
+	for iter.Next(ctx) {
+		value, err := r.docs.get(ctx, r.client, iter.Val())
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return err
+		}
+		var voteItem schema.Vote
+		if err := json.Unmarshal(value, &voteItem); err != nil {
+			log.Printf("Not indexing %s: %v", iter.Val(), err)
+			continue
+		}
+
+		_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			indexVote(ctx, pipe, voteItem)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// indexVote records a committed vote in the listing indexes
+func indexVote(ctx context.Context, pipe redis.Pipeliner, vote schema.Vote) {
+	pollID, voterID := linkID(vote.PollID), linkID(vote.VoterID)
+	pipe.ZAdd(ctx, voteIndexKey, &redis.Z{Score: float64(vote.VoteID), Member: vote.VoteID})
+	pipe.ZAdd(ctx, voteTimeIndexKey, &redis.Z{Score: float64(castMillis(vote)), Member: vote.VoteID})
+	pipe.ZAdd(ctx, voteCastIndexKey, &redis.Z{Score: 0, Member: castIndexMember(vote.VoteID, timeKey(castMillis(vote)))})
+	pipe.SAdd(ctx, pollVotesIndexKey(pollID), vote.VoteID)
+	pipe.SAdd(ctx, voterVotesIndexKey(voterID), vote.VoteID)
+	for _, optionID := range voteOptions(vote) {
+		pipe.SAdd(ctx, pollOptionIndexKey(pollID, optionID), vote.VoteID)
+	}
+}
+
+// unindexVote takes a vote out of the listing indexes
+func unindexVote(ctx context.Context, pipe redis.Pipeliner, vote schema.Vote) {
+	pollID, voterID := linkID(vote.PollID), linkID(vote.VoterID)
+	pipe.ZRem(ctx, voteIndexKey, vote.VoteID)
+	pipe.ZRem(ctx, voteTimeIndexKey, vote.VoteID)
+	pipe.ZRem(ctx, voteCastIndexKey, castIndexMember(vote.VoteID, timeKey(castMillis(vote))))
+	pipe.SRem(ctx, pollVotesIndexKey(pollID), vote.VoteID)
+	pipe.SRem(ctx, voterVotesIndexKey(voterID), vote.VoteID)
+	for _, optionID := range voteOptions(vote) {
+		pipe.SRem(ctx, pollOptionIndexKey(pollID, optionID), vote.VoteID)
+	}
+}
+
+func (r *RedisVoteRepository) Get(ctx context.Context, id uint) (schema.Vote, error) {
+	var voteItem schema.Vote
+
+	value, err := r.docs.get(ctx, r.client, voteKey(id))
+	if err == redis.Nil {
+		return voteItem, ErrNotFound
+	} else if err != nil {
+		return voteItem, err
+	}
+
+	err = json.Unmarshal(value, &voteItem)
+	return voteItem, err
+}
+
+func (r *RedisVoteRepository) List(ctx context.Context, filter VoteFilter, page Page) ([]schema.Vote, string, error) {
+	var ids []uint
+	var next string
+	var err error
+	if filter == (VoteFilter{}) && page.byID() {
+		ids, next, err = r.pageByID(ctx, page)
+	} else if len(filterIndexKeys(filter)) == 0 && page.Sort == SortByTime {
+		ids, next, err = r.pageByTime(ctx, filter, page)
+	} else {
+		ids, next, err = r.query(ctx, filter, page)
+	}
+	if err != nil || len(ids) == 0 {
+		return nil, "", err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = voteKey(id)
+	}
+	values, err := r.docs.mget(ctx, keys...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var voteList []schema.Vote
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var voteItem schema.Vote
+		if err := json.Unmarshal([]byte(str), &voteItem); err != nil {
+			return nil, "", fmt.Errorf("could not read %s: %w", keys[i], err)
+		}
+		voteList = append(voteList, voteItem)
+	}
+	return voteList, next, nil
+}
+
+// pageByID reads an unfiltered page in ID order straight from the ID index
+func (r *RedisVoteRepository) pageByID(ctx context.Context, page Page) ([]uint, string, error) {
+	min, max, err := page.scoreRange()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Ask the index for one vote more than the page to know if there is a
+	// next page
+	by := &redis.ZRangeBy{Min: min, Max: max, Count: int64(page.Limit) + 1}
+	var members []string
+	if page.Desc {
+		members, err = r.client.ZRevRangeByScore(ctx, voteIndexKey, by).Result()
+	} else {
+		members, err = r.client.ZRangeByScore(ctx, voteIndexKey, by).Result()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids, next := limitItems(idItems(parseIDs(members)), page)
+	return ids, next, nil
+}
+
+// pageByTime reads a page in CastAt order, within the filter's time range,
+// straight from the cast index
+func (r *RedisVoteRepository) pageByTime(ctx context.Context, filter VoteFilter, page Page) ([]uint, string, error) {
+	after, ok, err := page.after()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The cast index is ordered oldest first. A cursor narrows the range,
+	// unless it lies outside it.
+	oldest, newest := "-", "+"
+	if filter.From != nil {
+		oldest = "[" + timeKey(filter.From.UnixMilli())
+	}
+	if filter.To != nil {
+		newest = "(" + timeKey(filter.To.UnixMilli()+1)
+	}
+	if ok {
+		cursor := castIndexMember(after.id, after.key)
+		if page.Desc && (newest == "+" || cursor < newest[1:]) {
+			newest = "(" + cursor
+		} else if !page.Desc && (oldest == "-" || cursor >= oldest[1:]) {
+			oldest = "(" + cursor
+		}
+	}
+
+	// Ask the index for one vote more than the page to know if there is a
+	// next page
+	by := &redis.ZRangeBy{Min: oldest, Max: newest, Count: int64(page.Limit) + 1}
+	var members []string
+	if page.Desc {
+		members, err = r.client.ZRevRangeByLex(ctx, voteCastIndexKey, by).Result()
+	} else {
+		members, err = r.client.ZRangeByLex(ctx, voteCastIndexKey, by).Result()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var items []sortItem
+	for _, member := range members {
+		if item, ok := parseCastIndexMember(member); ok {
+			items = append(items, item)
+		}
+	}
+	ids, next := limitItems(items, page)
+	return ids, next, nil
+}
+
+// query works out a filtered page from the secondary indexes alone, so only
+// the votes on the page are read. The index sets of the filter are read
+// whole and intersected; only a time range on its own is read from the time
+// index, otherwise the cast times of the remaining votes are looked up.
+func (r *RedisVoteRepository) query(ctx context.Context, filter VoteFilter, page Page) ([]uint, string, error) {
+	var sets [][]uint
+	for _, key := range filterIndexKeys(filter) {
+		members, err := r.client.SMembers(ctx, key).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		sets = append(sets, parseIDs(members))
+	}
+	ids := intersect(sets...)
+
+	castAt := map[uint]string{}
+	if ids == nil {
+		// List routes every other listing elsewhere, so there is a time
+		// range
+		by := &redis.ZRangeBy{Min: "-inf", Max: "+inf"}
+		if filter.From != nil {
+			by.Min = strconv.FormatInt(filter.From.UnixMilli(), 10)
+		}
+		if filter.To != nil {
+			by.Max = strconv.FormatInt(filter.To.UnixMilli(), 10)
+		}
+		cast, err := r.client.ZRangeByScoreWithScores(ctx, voteTimeIndexKey, by).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		ids = []uint{}
+		for _, z := range cast {
+			id, err := strconv.ParseUint(fmt.Sprint(z.Member), 10, 64)
+			if err != nil {
+				continue
+			}
+			castAt[uint(id)] = timeKey(int64(z.Score))
+			ids = append(ids, uint(id))
+		}
+	} else if len(ids) > 0 && (filter.From != nil || filter.To != nil || page.Sort == SortByTime) {
+		members := make([]string, len(ids))
+		for i, id := range ids {
+			members[i] = strconv.FormatUint(uint64(id), 10)
+		}
+		scores, err := r.client.ZMScore(ctx, voteTimeIndexKey, members...).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		kept := []uint{}
+		for i, id := range ids {
+			millis := int64(scores[i])
+			if filter.From != nil && millis < filter.From.UnixMilli() || filter.To != nil && millis > filter.To.UnixMilli() {
+				continue
+			}
+			castAt[id] = timeKey(millis)
+			kept = append(kept, id)
+		}
+		ids = kept
+	}
+
+	items := idItems(ids)
+	if page.Sort == SortByTime {
+		for i := range items {
+			items[i].key = castAt[items[i].id]
+		}
+	}
+	return pageItems(items, page)
+}
+
+// filterIndexKeys lists the index sets the filter's poll, voter and option
+// restrict the votes to
+func filterIndexKeys(filter VoteFilter) []string {
+	var keys []string
+	switch {
+	case filter.OptionID != nil:
+		keys = append(keys, pollOptionIndexKey(filter.PollID, *filter.OptionID))
+	case filter.PollID != "":
+		keys = append(keys, pollVotesIndexKey(filter.PollID))
+	}
+	if filter.VoterID != "" {
+		keys = append(keys, voterVotesIndexKey(filter.VoterID))
+	}
+	return keys
+}
+
+func (r *RedisVoteRepository) NextID(ctx context.Context) (uint, error) {
+	id, err := r.client.Incr(ctx, nextVoteIDKey).Result()
+	return uint(id), err
+}
+
+func (r *RedisVoteRepository) Exists(ctx context.Context, id uint) (bool, error) {
+	found, err := r.client.Exists(ctx, voteKey(id), pendingKey(id), auditKey(id)).Result()
+	return found > 0, err
+}
+
+func (r *RedisVoteRepository) ClaimBallot(ctx context.Context, pollID string, voterID string, voteID uint) (bool, error) {
+	return r.client.SetNX(ctx, ballotKey(pollID, voterID), voteKey(voteID), 0).Result()
+}
+
+func (r *RedisVoteRepository) ReleaseBallot(ctx context.Context, pollID string, voterID string, voteID uint) error {
+	return releaseBallotScript.Run(ctx, r.client, []string{ballotKey(pollID, voterID)}, voteKey(voteID)).Err()
+}
+
+func (r *RedisVoteRepository) BeginPending(ctx context.Context, pv PendingVote) error {
+	pendingJSON, err := json.Marshal(pv)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{voteKey(pv.VoteID), pendingKey(pv.VoteID), auditKey(pv.VoteID), pendingVotesKey}
+	begun, err := beginPendingScript.Run(ctx, r.client, keys, pendingJSON, time.Now().Unix(), pv.VoteID).Int()
+	if err != nil {
+		return err
+	} else if begun == 0 {
+		return ErrExists
+	}
+	return nil
+}
+
+func (r *RedisVoteRepository) CommitPending(ctx context.Context, voteID uint) error {
+	key := pendingKey(voteID)
+
+	// WATCH the pending vote so committing it twice counts it only once
+	return r.client.Watch(ctx, func(tx *redis.Tx) error {
+		value, err := tx.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		var pv PendingVote
+		if err := json.Unmarshal(value, &pv); err != nil {
+			return err
+		}
+		VoteJSON, err := json.Marshal(pv.Vote)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.docs.set(ctx, pipe, voteKey(voteID), VoteJSON)
+			indexVote(ctx, pipe, pv.Vote)
+			tallyVote(ctx, pipe, pv.Vote, 1)
+			pipe.Del(ctx, key)
+			pipe.ZRem(ctx, pendingVotesKey, voteID)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+// tallyVote adds a vote to its poll's tally, or with by -1 takes it out
+func tallyVote(ctx context.Context, pipe redis.Pipeliner, vote schema.Vote, by int64) {
+	pollID := linkID(vote.PollID)
+	for _, inc := range voteIncrements(vote) {
+		hash := resultsKey(pollID)
+		if inc.scores {
+			hash = scoresKey(pollID)
+		}
+		pipe.HIncrBy(ctx, hash, inc.field, by)
+	}
+	if len(vote.VoteRanking) == 0 {
+		return
+	}
+	field := strconv.Itoa(int(vote.VoteID))
+	if by < 0 {
+		pipe.HDel(ctx, rankedBallotsKey(pollID), field)
+	} else {
+		ranking, _ := json.Marshal(vote.VoteRanking)
+		pipe.HSet(ctx, rankedBallotsKey(pollID), field, ranking)
+	}
+}
+
+func (r *RedisVoteRepository) DropPending(ctx context.Context, voteID uint) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, pendingKey(voteID))
+		pipe.ZRem(ctx, pendingVotesKey, voteID)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisVoteRepository) DuePending(ctx context.Context, before time.Time) ([]PendingVote, error) {
+	ids, err := r.client.ZRangeByScore(ctx, pendingVotesKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(before.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var due []PendingVote
+	for _, member := range ids {
+		id, err := strconv.ParseUint(strings.TrimPrefix(member, "vote-"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		value, err := r.client.Get(ctx, pendingKey(uint(id))).Bytes()
+		if err == redis.Nil {
+			// Committed or dropped since it was listed
+			r.client.ZRem(ctx, pendingVotesKey, member)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		var pv PendingVote
+		if err := json.Unmarshal(value, &pv); err != nil {
+			log.Printf("Skipping unreadable pending vote %d: %v", id, err)
+			continue
+		}
+		due = append(due, pv)
+	}
+	return due, nil
+}
+
+func (r *RedisVoteRepository) Tally(ctx context.Context, pollID string) (Tally, error) {
+	results, err := r.client.HGetAll(ctx, resultsKey(pollID)).Result()
+	if err != nil {
+		return Tally{}, err
+	}
+	scores, err := r.client.HGetAll(ctx, scoresKey(pollID)).Result()
+	if err != nil {
+		return Tally{}, err
+	}
+	rankings, err := r.client.HVals(ctx, rankedBallotsKey(pollID)).Result()
+	if err != nil {
+		return Tally{}, err
+	}
+	return parseTally(results, scores, rankings)
+}
+
+func (r *RedisVoteRepository) DeletePollVotes(ctx context.Context, pollID string) ([]schema.Vote, error) {
+	members, err := r.client.SMembers(ctx, pollVotesIndexKey(pollID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	ids := parseIDs(members)
+
+	var votes []schema.Vote
+	if len(ids) > 0 {
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = voteKey(id)
+		}
+		values, err := r.docs.mget(ctx, keys...)
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			str, ok := value.(string)
+			if !ok {
+				continue
+			}
+			var voteItem schema.Vote
+			if err := json.Unmarshal([]byte(str), &voteItem); err != nil {
+				return nil, fmt.Errorf("could not read %s: %w", keys[i], err)
+			}
+			votes = append(votes, voteItem)
+		}
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, vote := range votes {
+			pipe.Del(ctx, voteKey(vote.VoteID), ballotKey(pollID, linkID(vote.VoterID)))
+			unindexVote(ctx, pipe, vote)
+		}
+		pipe.Del(ctx, pollVotesIndexKey(pollID), resultsKey(pollID), scoresKey(pollID), rankedBallotsKey(pollID))
+		return nil
+	})
+	return votes, err
+}
+
+func (r *RedisVoteRepository) AnonymizeVoter(ctx context.Context, voterID string) (int, error) {
+	members, err := r.client.SMembers(ctx, voterVotesIndexKey(voterID)).Result()
+	if err != nil {
+		return 0, err
+	}
+	ids := parseIDs(members)
+	if len(ids) == 0 {
+		return 0, r.DropAnonymize(ctx, voterID)
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = voteKey(id)
+	}
+	values, err := r.docs.mget(ctx, keys...)
+	if err != nil {
+		return 0, err
+	}
+
+	var votes []schema.Vote
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var voteItem schema.Vote
+		if err := json.Unmarshal([]byte(str), &voteItem); err != nil {
+			return 0, fmt.Errorf("could not read %s: %w", keys[i], err)
+		}
+		votes = append(votes, voteItem)
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, vote := range votes {
+			pipe.Del(ctx, ballotKey(linkID(vote.PollID), voterID))
+			vote.VoterID = ""
+			VoteJSON, _ := json.Marshal(vote)
+			r.docs.set(ctx, pipe, voteKey(vote.VoteID), VoteJSON)
+		}
+		pipe.Del(ctx, voterVotesIndexKey(voterID))
+		pipe.ZRem(ctx, anonymizeKey, voterID)
+		return nil
+	})
+	return len(votes), err
+}
+
+func (r *RedisVoteRepository) QueueHistoryFix(ctx context.Context, fix HistoryFix) error {
+	fixJSON, err := json.Marshal(fix)
+	if err != nil {
+		return err
+	}
+	return r.client.ZAdd(ctx, historyFixesKey, &redis.Z{Score: float64(time.Now().Unix()), Member: fixJSON}).Err()
+}
+
+func (r *RedisVoteRepository) DueHistoryFixes(ctx context.Context, before time.Time) ([]HistoryFix, error) {
+	members, err := r.client.ZRangeByScore(ctx, historyFixesKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(before.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var due []HistoryFix
+	for _, member := range members {
+		var fix HistoryFix
+		if err := json.Unmarshal([]byte(member), &fix); err != nil {
+			log.Printf("Skipping unreadable history fix %q: %v", member, err)
+			continue
+		}
+		due = append(due, fix)
+	}
+	return due, nil
+}
+
+func (r *RedisVoteRepository) DropHistoryFix(ctx context.Context, fix HistoryFix) error {
+	fixJSON, err := json.Marshal(fix)
+	if err != nil {
+		return err
+	}
+	return r.client.ZRem(ctx, historyFixesKey, fixJSON).Err()
+}
+
+func (r *RedisVoteRepository) QueueAnonymize(ctx context.Context, voterID string) error {
+	return r.client.ZAdd(ctx, anonymizeKey, &redis.Z{Score: float64(time.Now().Unix()), Member: voterID}).Err()
+}
+
+func (r *RedisVoteRepository) DropAnonymize(ctx context.Context, voterID string) error {
+	return r.client.ZRem(ctx, anonymizeKey, voterID).Err()
+}
+
+func (r *RedisVoteRepository) DueAnonymize(ctx context.Context, before time.Time) ([]string, error) {
+	return r.client.ZRangeByScore(ctx, anonymizeKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(before.Unix(), 10),
+	}).Result()
+}
+
+func (r *RedisVoteRepository) Update(ctx context.Context, id uint, fn func(*schema.Vote) error) (schema.Vote, error) {
+	var voteItem schema.Vote
+	key := voteKey(id)
+
+	// WATCH the vote so a concurrent change or retraction is never counted
+	// twice
+	txf := func(tx *redis.Tx) error {
+		previous, err := r.getVote(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		voteItem = previous
+		if err := fn(&voteItem); err != nil {
+			return err
+		}
+		voteItem.VoteID = id
+
+		VoteJSON, err := json.Marshal(voteItem)
+		if err != nil {
+			return err
+		}
+		audit, err := auditEntry(schema.VoteChanged, previous)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			unindexVote(ctx, pipe, previous)
+			tallyVote(ctx, pipe, previous, -1)
+			r.docs.set(ctx, pipe, key, VoteJSON)
+			indexVote(ctx, pipe, voteItem)
+			tallyVote(ctx, pipe, voteItem, 1)
+			pipe.RPush(ctx, auditKey(id), audit)
+			return nil
+		})
+		return err
+	}
+	return voteItem, r.watch(ctx, key, txf)
+}
+
+func (r *RedisVoteRepository) Delete(ctx context.Context, id uint, fn func(schema.Vote) error) (schema.Vote, error) {
+	var voteItem schema.Vote
+	key := voteKey(id)
+
+	txf := func(tx *redis.Tx) error {
+		var err error
+		voteItem, err = r.getVote(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(voteItem); err != nil {
+			return err
+		}
+		audit, err := auditEntry(schema.VoteRetracted, voteItem)
+		if err != nil {
+			return err
+		}
+
+		ballot := ballotKey(linkID(voteItem.PollID), linkID(voteItem.VoterID))
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			unindexVote(ctx, pipe, voteItem)
+			tallyVote(ctx, pipe, voteItem, -1)
+			releaseBallotScript.Eval(ctx, pipe, []string{ballot}, key)
+			pipe.RPush(ctx, auditKey(id), audit)
+			return nil
+		})
+		return err
+	}
+	return voteItem, r.watch(ctx, key, txf)
+}
+
+func (r *RedisVoteRepository) AuditTrail(ctx context.Context, id uint) ([]schema.VoteAudit, error) {
+	values, err := r.client.LRange(ctx, auditKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	trail := []schema.VoteAudit{}
+	for _, value := range values {
+		var entry schema.VoteAudit
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, fmt.Errorf("unreadable audit entry of vote %d: %w", id, err)
+		}
+		trail = append(trail, entry)
+	}
+	return trail, nil
+}
+
+// watch runs txf with the vote's key WATCHed, retrying it while another
+// writer keeps getting in between
+func (r *RedisVoteRepository) watch(ctx context.Context, key string, txf func(*redis.Tx) error) error {
+	for i := 0; i < maxTxRetries; i++ {
+		err := r.client.Watch(ctx, txf, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("vote %s changed too often to update", key)
+}
+
+func (r *RedisVoteRepository) getVote(ctx context.Context, tx *redis.Tx, id uint) (schema.Vote, error) {
+	var voteItem schema.Vote
+	value, err := r.docs.get(ctx, tx, voteKey(id))
+	if err == redis.Nil {
+		return voteItem, ErrNotFound
+	} else if err != nil {
+		return voteItem, err
+	}
+	err = json.Unmarshal(value, &voteItem)
+	return voteItem, err
+}
+
+func (r *RedisVoteRepository) TryLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, name, owner, ttl).Result()
+}
@@ -0,0 +1,287 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"votes-api/schema"
+)
+
+const (
+	RedisNilError        = "redis: nil"
+	RedisDefaultLocation = "0.0.0.0:6379"
+
+	maxTxRetries = 10
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+)
+
+// SortByTime orders the vote listing by CastAt
+const SortByTime = "time"
+
+// VoteFilter narrows the vote listing. Zero fields match every vote.
+type VoteFilter struct {
+	PollID   string
+	VoterID  string
+	OptionID *uint      // Votes counted towards the option. Needs PollID, as option IDs are per poll.
+	From     *time.Time // CastAt range, both ends included
+	To       *time.Time
+}
+
+func (f VoteFilter) matches(vote schema.Vote) bool {
+	if f.PollID != "" && linkID(vote.PollID) != f.PollID {
+		return false
+	}
+	if f.VoterID != "" && linkID(vote.VoterID) != f.VoterID {
+		return false
+	}
+	if f.OptionID != nil && !countsTowards(vote, *f.OptionID) {
+		return false
+	}
+	cast := castMillis(vote)
+	if f.From != nil && cast < f.From.UnixMilli() {
+		return false
+	}
+	return f.To == nil || cast <= f.To.UnixMilli()
+}
+
+// linkID is the ID at the end of a link like "/polls/1"
+func linkID(link string) string {
+	return link[strings.LastIndex(link, "/")+1:]
+}
+
+// castMillis is when the vote was cast in unix milliseconds. Votes stored
+// before CastAt existed count as cast at 0.
+func castMillis(vote schema.Vote) int64 {
+	if vote.CastAt == nil {
+		return 0
+	}
+	return vote.CastAt.UnixMilli()
+}
+
+// timeKey is what votes are sorted by time on, given their castMillis
+func timeKey(millis int64) string {
+	return fmt.Sprintf("%020d", millis)
+}
+
+// voteOptions lists the options a vote is counted towards in the results
+func voteOptions(vote schema.Vote) []uint {
+	var options []uint
+	for _, inc := range voteIncrements(vote) {
+		if inc.scores || inc.field == ballotsField {
+			continue
+		}
+		optionID, err := strconv.ParseUint(inc.field, 10, 64)
+		if err == nil {
+			options = append(options, uint(optionID))
+		}
+	}
+	return options
+}
+
+func countsTowards(vote schema.Vote, optionID uint) bool {
+	for _, id := range voteOptions(vote) {
+		if id == optionID {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingVote is an outbox entry for a vote that is not committed yet
+type PendingVote struct {
+	VoteID  uint
+	PollID  string
+	VoterID string
+	Vote    schema.Vote
+}
+
+// HistoryFix is an outbox entry for a voter's VoteHistory entry that may be
+// out of date, because a call to voter-api failed
+type HistoryFix struct {
+	VoterLink string
+	VoteID    uint
+}
+
+// Tally is what a poll's committed votes add up to
+type Tally struct {
+	Ballots  int64                   // Votes cast
+	Options  map[uint]int64          // Votes (or ratings) per PollOptionID
+	Scores   map[uint]map[uint]int64 // Score polls: how often each score was given per PollOptionID
+	Rankings [][]uint                // Ranked polls: every ballot's ranking
+}
+
+// VoteRepository stores votes together with what is derived from them: the
+// one-ballot-per-voter claims, the outbox of votes being submitted and each
+// poll's tally. There is a Redis implementation for production and an
+// in-memory one for running the service without Redis.
+type VoteRepository interface {
+	Get(ctx context.Context, id uint) (schema.Vote, error)
+	// List returns a page of the committed votes matching the filter and
+	// the cursor for the next page ("" after the last page)
+	List(ctx context.Context, filter VoteFilter, page Page) ([]schema.Vote, string, error)
+
+	// NextID allocates an ID for a new vote. IDs are never handed out
+	// twice, but a client may have picked one already.
+	NextID(ctx context.Context) (uint, error)
+
+	// Exists reports whether the vote ID is taken by a stored or a pending
+	// vote, or by one that has an audit trail. Retracted votes keep their
+	// IDs, so a new vote cannot take over their trail.
+	Exists(ctx context.Context, id uint) (bool, error)
+
+	// ClaimBallot reserves the voter's ballot in the poll for the vote. It
+	// returns false if the voter already voted in the poll. ReleaseBallot
+	// only gives the ballot back if it is still held by the same vote.
+	ClaimBallot(ctx context.Context, pollID string, voterID string, voteID uint) (bool, error)
+	ReleaseBallot(ctx context.Context, pollID string, voterID string, voteID uint) error
+
+	// BeginPending records a vote in the outbox, or returns ErrExists if its
+	// ID is taken as Exists sees it, checked in the same step. CommitPending
+	// then stores it, counts it and removes it from the outbox in one step,
+	// returning ErrNotFound if it is no longer pending; DropPending discards
+	// it.
+	BeginPending(ctx context.Context, pv PendingVote) error
+	CommitPending(ctx context.Context, voteID uint) error
+	DropPending(ctx context.Context, voteID uint) error
+
+	// DuePending lists the pending votes recorded before the cutoff
+	DuePending(ctx context.Context, before time.Time) ([]PendingVote, error)
+
+	Tally(ctx context.Context, pollID string) (Tally, error)
+
+	// DeletePollVotes deletes every committed vote in the poll along with
+	// its tally and ballots, returning the deleted votes
+	DeletePollVotes(ctx context.Context, pollID string) ([]schema.Vote, error)
+
+	// AnonymizeVoter clears the VoterID of every committed vote the voter
+	// cast and gives their ballots back, returning how many votes changed.
+	// The votes keep counting in their polls' tallies.
+	AnonymizeVoter(ctx context.Context, voterID string) (int, error)
+
+	// QueueHistoryFix records that the voter's VoteHistory entry for the
+	// vote needs to be brought in line with the vote. DueHistoryFixes lists
+	// the fixes queued before the cutoff and DropHistoryFix takes one off
+	// the queue once it is done.
+	QueueHistoryFix(ctx context.Context, fix HistoryFix) error
+	DueHistoryFixes(ctx context.Context, before time.Time) ([]HistoryFix, error)
+	DropHistoryFix(ctx context.Context, fix HistoryFix) error
+
+	// QueueAnonymize records that the voter is about to be deleted, so
+	// their votes are anonymized even if nobody asks for it afterwards.
+	// AnonymizeVoter takes the voter off the queue again, DropAnonymize
+	// does so without anonymizing. DueAnonymize lists the voters queued
+	// before the cutoff.
+	QueueAnonymize(ctx context.Context, voterID string) error
+	DropAnonymize(ctx context.Context, voterID string) error
+	DueAnonymize(ctx context.Context, before time.Time) ([]string, error)
+
+	// Update applies fn to a committed vote atomically, moving its counts in
+	// the tally and the indexes to the new value. Delete removes a committed
+	// vote with its counts and gives its ballot back once fn approves. Both
+	// record the vote as it was in its audit trail and return fn's error as
+	// is. fn must not move the vote to another poll or voter.
+	Update(ctx context.Context, id uint, fn func(*schema.Vote) error) (schema.Vote, error)
+	Delete(ctx context.Context, id uint, fn func(schema.Vote) error) (schema.Vote, error)
+
+	// AuditTrail lists the changes made to a vote, oldest first. It outlives
+	// the vote, so retracted votes keep theirs.
+	AuditTrail(ctx context.Context, id uint) ([]schema.VoteAudit, error)
+
+	// TryLock takes the named lock for ttl if nobody else holds it
+	TryLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error)
+}
+
+// Both backends keep a poll's tally in string-keyed counters, the same way
+// Redis hashes do: the results counters hold the ballot count and one
+// counter per PollOptionID, the scores counters hold one counter per
+// "<PollOptionID>:<Score>". Ranked ballots are kept per vote.
+const ballotsField = "ballots"
+
+type increment struct {
+	scores bool // false for the results counters
+	field  string
+}
+
+// voteIncrements lists the counters a vote adds one to. For ranked polls
+// the results hold first preferences, for approval and multi-select polls
+// every selected option is counted and for score polls every rated option.
+func voteIncrements(vote schema.Vote) []increment {
+	increments := []increment{{field: ballotsField}}
+	switch {
+	case len(vote.VoteSelections) > 0:
+		for _, optionID := range vote.VoteSelections {
+			increments = append(increments, increment{field: strconv.Itoa(int(optionID))})
+		}
+	case len(vote.VoteScores) > 0:
+		for _, rating := range vote.VoteScores {
+			increments = append(increments,
+				increment{field: strconv.Itoa(int(rating.PollOptionID))},
+				increment{scores: true, field: fmt.Sprintf("%d:%d", rating.PollOptionID, rating.Score)},
+			)
+		}
+	default:
+		increments = append(increments, increment{field: strconv.Itoa(int(vote.VoteValue))})
+	}
+	return increments
+}
+
+// auditEntry is what Update and Delete record in a vote's audit trail
+func auditEntry(action schema.VoteAction, previous schema.Vote) ([]byte, error) {
+	return json.Marshal(schema.VoteAudit{
+		VoteID:   previous.VoteID,
+		Action:   action,
+		At:       time.Now().UTC(),
+		Previous: previous,
+	})
+}
+
+// parseTally turns the stored counters and rankings into a Tally
+func parseTally(results map[string]string, scores map[string]string, rankings []string) (Tally, error) {
+	tally := Tally{
+		Options: map[uint]int64{},
+		Scores:  map[uint]map[uint]int64{},
+	}
+
+	for field, value := range results {
+		count, _ := strconv.ParseInt(value, 10, 64)
+		if field == ballotsField {
+			tally.Ballots = count
+			continue
+		}
+		optionID, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		tally.Options[uint(optionID)] = count
+	}
+
+	for field, value := range scores {
+		var optionID, score uint
+		if _, err := fmt.Sscanf(field, "%d:%d", &optionID, &score); err != nil {
+			continue
+		}
+		count, _ := strconv.ParseInt(value, 10, 64)
+		if tally.Scores[optionID] == nil {
+			tally.Scores[optionID] = map[uint]int64{}
+		}
+		tally.Scores[optionID][score] = count
+	}
+
+	for _, value := range rankings {
+		var ranking []uint
+		if err := json.Unmarshal([]byte(value), &ranking); err != nil {
+			return tally, fmt.Errorf("unreadable ranked ballot: %w", err)
+		}
+		tally.Rankings = append(tally.Rankings, ranking)
+	}
+	return tally, nil
+}